@@ -21,7 +21,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/google-gemini/proxy-to-gemini/internal"
 	"github.com/google-gemini/proxy-to-gemini/ollama"
 	"github.com/google-gemini/proxy-to-gemini/openai"
 	"github.com/google/generative-ai-go/genai"
@@ -33,6 +35,17 @@ var (
 	apikey   string
 	hostport string
 	api      string
+
+	allowImageURLs bool
+	maxImageSize   int64
+
+	embeddingTaskTypes string
+	tokenCountModel    string
+
+	contextTTL      time.Duration
+	maxContexts     int
+	maxContextBytes int64
+	aliasesFile     string
 )
 
 func main() {
@@ -40,8 +53,21 @@ func main() {
 
 	flag.StringVar(&hostport, "listen", ":5555", "host and port to listen on")
 	flag.StringVar(&api, "api", "openai", "API proxocol; openai or ollama")
+	flag.BoolVar(&allowImageURLs, "allow-image-urls", false, "allow the proxy to download http(s) image URLs sent in chat messages")
+	flag.Int64Var(&maxImageSize, "max-image-size", 20<<20, "maximum size of an image in bytes")
+	flag.StringVar(&embeddingTaskTypes, "embedding-task-types", "", "comma separated model=TASK_TYPE pairs setting the default embedding task type of models, e.g. text-embedding-004=RETRIEVAL_DOCUMENT")
+	flag.StringVar(&tokenCountModel, "token-count-model", "gemini-1.5-flash", "Gemini model that counts the tokens of openai embedding inputs for usage; if empty, usage is reported as zero")
+	flag.DurationVar(&contextTTL, "context-ttl", 30*time.Minute, "how long ollama generate conversations are kept after their last use")
+	flag.IntVar(&maxContexts, "max-contexts", 1000, "maximum number of ollama generate conversations kept")
+	flag.Int64Var(&maxContextBytes, "max-context-bytes", 256<<20, "maximum size in bytes of the texts and images of the ollama generate conversations kept")
+	flag.StringVar(&aliasesFile, "aliases-file", "ollama-aliases.json", "file ollama model aliases are saved to; if empty, they are kept in memory only")
 	flag.Parse()
 
+	taskTypes, err := internal.ParseTaskTypes(embeddingTaskTypes)
+	if err != nil {
+		log.Fatalf("invalid -embedding-task-types: %v", err)
+	}
+
 	apikey = os.Getenv("GEMINI_API_KEY")
 	if apikey == "" {
 		log.Fatal("GEMINI_API_KEY environment variable not set")
@@ -58,9 +84,21 @@ func main() {
 	})
 	switch api {
 	case "openai":
-		openai.RegisterHandlers(r, client)
+		openai.RegisterHandlers(r, client, openai.Config{
+			AllowImageURLs:     allowImageURLs,
+			MaxImageSize:       maxImageSize,
+			EmbeddingTaskTypes: taskTypes,
+			TokenCountModel:    tokenCountModel,
+		})
 	case "ollama":
-		ollama.RegisterHandlers(r, client)
+		ollama.RegisterHandlers(r, client, ollama.Config{
+			EmbeddingTaskTypes: taskTypes,
+			MaxImageSize:       maxImageSize,
+			ContextTTL:         contextTTL,
+			MaxContexts:        maxContexts,
+			MaxContextBytes:    maxContextBytes,
+			AliasesFile:        aliasesFile,
+		})
 	}
 	r.HandleFunc("/", indexHandler)
 
@@ -0,0 +1,132 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxEmbeddingBatchSize is the maximum number of contents
+	// Gemini accepts in a single BatchEmbedContents call.
+	maxEmbeddingBatchSize = 100
+
+	// embeddingConcurrency is the maximum number of batches
+	// of a single request that are embedded at the same time.
+	embeddingConcurrency = 4
+
+	// embeddingRetries is how many times a batch is retried
+	// if Gemini is overloaded or rate limits the proxy.
+	embeddingRetries = 3
+)
+
+// embeddingRetryDelay is the delay before the first retry.
+// It doubles with every retry.
+var embeddingRetryDelay = time.Second
+
+// BatchEmbed returns the embeddings of texts in the same order.
+// The task type of model is used for every text, and so is title
+// if it isn't empty.
+//
+// Gemini limits the number of contents in a batch, so texts are split
+// into batches that are embedded concurrently. Batches that fail with
+// a 429 or 503 are retried on their own, any other failure fails the
+// whole call.
+func BatchEmbed(ctx context.Context, model *genai.EmbeddingModel, title string, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(embeddingConcurrency)
+	for start := 0; start < len(texts); start += maxEmbeddingBatchSize {
+		start, end := start, min(start+maxEmbeddingBatchSize, len(texts))
+		g.Go(func() error {
+			batch := model.NewBatch()
+			for _, text := range texts[start:end] {
+				batch.AddContentWithTitle(title, genai.Text(text))
+			}
+			resp, err := batchEmbedWithRetry(ctx, model, batch)
+			if err != nil {
+				return err
+			}
+			if len(resp.Embeddings) != end-start {
+				return fmt.Errorf("got %d embeddings for %d inputs", len(resp.Embeddings), end-start)
+			}
+			for i, e := range resp.Embeddings {
+				embeddings[start+i] = e.Values
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return embeddings, nil
+}
+
+// CountTokens returns the number of tokens of texts, counted by model.
+// Texts are counted in batches of the size BatchEmbed embeds them in,
+// so that large inputs don't exceed the limits of a single request.
+func CountTokens(ctx context.Context, model *genai.GenerativeModel, texts []string) (int32, error) {
+	var total atomic.Int32
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(embeddingConcurrency)
+	for start := 0; start < len(texts); start += maxEmbeddingBatchSize {
+		start, end := start, min(start+maxEmbeddingBatchSize, len(texts))
+		g.Go(func() error {
+			parts := make([]genai.Part, 0, end-start)
+			for _, text := range texts[start:end] {
+				parts = append(parts, genai.Text(text))
+			}
+			resp, err := model.CountTokens(ctx, parts...)
+			if err != nil {
+				return err
+			}
+			total.Add(resp.TotalTokens)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, err
+	}
+	return total.Load(), nil
+}
+
+func batchEmbedWithRetry(ctx context.Context, model *genai.EmbeddingModel, batch *genai.EmbeddingBatch) (*genai.BatchEmbedContentsResponse, error) {
+	delay := embeddingRetryDelay
+	for i := 0; ; i++ {
+		resp, err := model.BatchEmbedContents(ctx, batch)
+		if err == nil {
+			return resp, nil
+		}
+		code := StatusCode(err)
+		if i == embeddingRetries || (code != http.StatusTooManyRequests && code != http.StatusServiceUnavailable) {
+			return nil, err
+		}
+		log.Printf("Retrying embeddings batch in %v: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func TestBatchEmbed(t *testing.T) {
+	embeddingRetryDelay = time.Millisecond
+
+	var calls, failures atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			Requests []struct {
+				Content struct {
+					Parts []struct {
+						Text string `json:"text"`
+					} `json:"parts"`
+				} `json:"content"`
+			} `json:"requests"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Requests) > maxEmbeddingBatchSize {
+			http.Error(w, "batch too large", http.StatusBadRequest)
+			return
+		}
+		// Rate limit the first batch once.
+		if req.Requests[0].Content.Parts[0].Text == "0" && failures.Add(1) == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"code": 429, "message": "slow down", "status": "RESOURCE_EXHAUSTED"}}`))
+			return
+		}
+		type embedding struct {
+			Values []float32 `json:"values"`
+		}
+		var resp struct {
+			Embeddings []embedding `json:"embeddings"`
+		}
+		for _, r := range req.Requests {
+			v, _ := strconv.Atoi(r.Content.Parts[0].Text)
+			resp.Embeddings = append(resp.Embeddings, embedding{Values: []float32{float32(v)}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey("test"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	texts := make([]string, 2*maxEmbeddingBatchSize+50)
+	for i := range texts {
+		texts[i] = strconv.Itoa(i)
+	}
+	got, err := BatchEmbed(ctx, client.EmbeddingModel("text-embedding-004"), "", texts)
+	if err != nil {
+		t.Fatalf("BatchEmbed() error = %v", err)
+	}
+	if len(got) != len(texts) {
+		t.Fatalf("BatchEmbed() returned %d embeddings, want %d", len(got), len(texts))
+	}
+	for i, e := range got {
+		if len(e) != 1 || e[0] != float32(i) {
+			t.Errorf("BatchEmbed()[%d] = %v, want [%d]", i, e, i)
+		}
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("BatchEmbed() made %d calls, want 4", n)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			GenerateContentRequest struct {
+				Contents []struct {
+					Parts []json.RawMessage `json:"parts"`
+				} `json:"contents"`
+			} `json:"generateContentRequest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contents := req.GenerateContentRequest.Contents
+		if len(contents) != 1 || len(contents[0].Parts) > maxEmbeddingBatchSize {
+			http.Error(w, "batch too large", http.StatusBadRequest)
+			return
+		}
+		// Every text is counted as two tokens.
+		json.NewEncoder(w).Encode(map[string]int{"totalTokens": 2 * len(contents[0].Parts)})
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey("test"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	texts := make([]string, maxEmbeddingBatchSize+1)
+	for i := range texts {
+		texts[i] = strconv.Itoa(i)
+	}
+	got, err := CountTokens(ctx, client.GenerativeModel("gemini-1.5-flash"), texts)
+	if err != nil {
+		t.Fatalf("CountTokens() error = %v", err)
+	}
+	if want := int32(2 * len(texts)); got != want {
+		t.Errorf("CountTokens() = %d, want %d", got, want)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("CountTokens() made %d calls, want 2", n)
+	}
+}
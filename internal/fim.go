@@ -0,0 +1,169 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// fillInTheMiddleInstruction asks Gemini, which has no native support
+// for fill-in-the-middle, to only produce the text between a prefix
+// and a suffix.
+const fillInTheMiddleInstruction = `You fill in the missing text of a document.
+You are given the text before the gap between <prefix> tags and the text after the gap between <suffix> tags.
+Reply with only the text that belongs in the gap so that prefix, your reply and suffix form the complete document.
+Do not repeat the prefix or the suffix, do not add explanations and do not wrap your reply in tags or code fences.`
+
+// FillInTheMiddle returns the system instruction and the prompt
+// that ask Gemini to complete the text between prefix and suffix.
+func FillInTheMiddle(prefix, suffix string) (system, prompt string) {
+	return fillInTheMiddleInstruction, "<prefix>" + prefix + "</prefix>\n<suffix>" + suffix + "</suffix>"
+}
+
+// maxEchoedLines is how many lines of the prefix or the suffix
+// are looked for when stripping them from a reply.
+const maxEchoedLines = 10
+
+// StripFillInTheMiddle removes what Gemini echoed of the prefix and the
+// suffix from a fill-in-the-middle reply, as well as a code fence.
+func StripFillInTheMiddle(reply, prefix, suffix string) string {
+	s := NewFillInTheMiddleStripper(prefix, suffix)
+	return s.Write(reply) + s.Flush()
+}
+
+// FillInTheMiddleStripper strips a streamed fill-in-the-middle reply the
+// same as StripFillInTheMiddle does. Until it knows whether the reply
+// starts by repeating the prefix, it holds the reply back. After that, it
+// holds back as much of the reply as could repeat the suffix.
+//
+// Gemini usually repeats whole lines, so only the last lines of the
+// prefix and the first lines of the suffix are stripped. This keeps
+// text that only happens to overlap with a character or two.
+type FillInTheMiddleStripper struct {
+	heads   []string // last lines of the prefix, longest first
+	tails   []string // first lines of the suffix, longest first
+	maxTail int
+
+	pending string
+	started bool // whether the start of the reply was stripped
+	fenced  bool // whether the reply is wrapped in a code fence
+}
+
+// NewFillInTheMiddleStripper returns a stripper for a
+// reply to the prompt returned by FillInTheMiddle.
+func NewFillInTheMiddleStripper(prefix, suffix string) *FillInTheMiddleStripper {
+	s := &FillInTheMiddleStripper{}
+	for i, lines := len(prefix)-1, 0; i >= 0 && lines < maxEchoedLines; i-- {
+		if i > 0 && prefix[i-1] != '\n' {
+			continue
+		}
+		lines++
+		if head := prefix[i:]; strings.TrimSpace(head) != "" {
+			s.heads = append([]string{head}, s.heads...)
+		}
+	}
+	for i, lines := 0, 0; i <= len(suffix) && lines < maxEchoedLines; i++ {
+		if i < len(suffix) && suffix[i] != '\n' {
+			continue
+		}
+		lines++
+		if tail := suffix[:i]; strings.TrimSpace(tail) != "" {
+			s.tails = append([]string{tail}, s.tails...)
+			s.maxTail = len(tail)
+		}
+	}
+	return s
+}
+
+// Write adds the next chunk of the reply and
+// returns the text that can be sent so far.
+func (s *FillInTheMiddleStripper) Write(chunk string) string {
+	s.pending += chunk
+	if !s.started && !s.stripHead(false) {
+		return ""
+	}
+	// Hold back enough to strip the suffix and
+	// a closing code fence from the end.
+	keep := s.maxTail + len("\n```\n")
+	if len(s.pending) <= keep {
+		return ""
+	}
+	cut := len(s.pending) - keep
+	for cut > 0 && !utf8.RuneStart(s.pending[cut]) {
+		cut--
+	}
+	out := s.pending[:cut]
+	s.pending = s.pending[cut:]
+	return out
+}
+
+// Flush returns the rest of the reply once it is complete.
+func (s *FillInTheMiddleStripper) Flush() string {
+	if !s.started {
+		s.stripHead(true)
+	}
+	text := s.pending
+	s.pending = ""
+	if s.fenced {
+		if t := strings.TrimRight(text, " \t\r\n"); strings.HasSuffix(t, "```") {
+			text = strings.TrimSuffix(strings.TrimSuffix(t, "```"), "\n")
+		}
+	}
+	trimmed := strings.TrimRight(text, " \t\r\n")
+	for _, tail := range s.tails {
+		if strings.HasSuffix(trimmed, tail) {
+			return trimmed[:len(trimmed)-len(tail)]
+		}
+	}
+	return text
+}
+
+// stripHead strips an opening code fence and the repeated prefix from
+// the start of the pending reply. It returns false if more of the reply
+// is needed to tell, unless the reply is final.
+func (s *FillInTheMiddleStripper) stripHead(final bool) bool {
+	if !s.fenced {
+		if !final && len(s.pending) < len("```") && strings.HasPrefix("```", s.pending) {
+			return false
+		}
+		if strings.HasPrefix(s.pending, "```") {
+			i := strings.IndexByte(s.pending, '\n')
+			if i < 0 {
+				if !final {
+					return false
+				}
+				i = len(s.pending) - 1
+			}
+			s.pending = s.pending[i+1:]
+			s.fenced = true
+		}
+	}
+	if !final {
+		for _, head := range s.heads {
+			if len(s.pending) < len(head) && strings.HasPrefix(head, s.pending) {
+				return false
+			}
+		}
+	}
+	for _, head := range s.heads {
+		if strings.HasPrefix(s.pending, head) {
+			s.pending = s.pending[len(head):]
+			break
+		}
+	}
+	s.started = true
+	return true
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripFillInTheMiddle(t *testing.T) {
+	const (
+		prefix = "func add(a, b int) int {\n\t"
+		suffix = "\n}\n\nfunc sub(a, b int) int {\n\treturn a - b\n}\n"
+	)
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+	}{
+		{
+			name:  "middle only",
+			reply: "return a + b",
+			want:  "return a + b",
+		},
+		{
+			name:  "repeated prefix",
+			reply: "func add(a, b int) int {\n\treturn a + b",
+			want:  "return a + b",
+		},
+		{
+			name:  "repeated suffix",
+			reply: "return a + b\n}\n\nfunc sub(a, b int) int {\n",
+			want:  "return a + b",
+		},
+		{
+			name:  "repeated prefix and suffix",
+			reply: "func add(a, b int) int {\n\treturn a + b\n}\n",
+			want:  "return a + b",
+		},
+		{
+			name:  "code fence",
+			reply: "```go\nreturn a + b\n```\n",
+			want:  "return a + b",
+		},
+		{
+			name:  "fenced with repeated prefix",
+			reply: "```go\nfunc add(a, b int) int {\n\treturn a + b\n}\n```",
+			want:  "return a + b",
+		},
+		{
+			name:  "partial overlap is kept",
+			reply: "{ return a + b }",
+			want:  "{ return a + b }",
+		},
+		{
+			name:  "multibyte",
+			reply: "return a + b // π ≈ 3.14",
+			want:  "return a + b // π ≈ 3.14",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripFillInTheMiddle(tt.reply, prefix, suffix); got != tt.want {
+				t.Errorf("StripFillInTheMiddle() = %q, want %q", got, tt.want)
+			}
+
+			// Streaming the reply a byte at a time strips the same.
+			s := NewFillInTheMiddleStripper(prefix, suffix)
+			var b strings.Builder
+			for i := 0; i < len(tt.reply); i++ {
+				b.WriteString(s.Write(tt.reply[i : i+1]))
+			}
+			b.WriteString(s.Flush())
+			if got := b.String(); got != tt.want {
+				t.Errorf("FillInTheMiddleStripper = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
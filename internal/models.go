@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// modelsCacheTTL is how long the list of Gemini models is cached.
+// Many clients list models on startup, there is no need to ask
+// Gemini every time.
+const modelsCacheTTL = 5 * time.Minute
+
+// ModelCache caches the list of Gemini models.
+// The zero value is ready to use.
+type ModelCache struct {
+	mu      sync.Mutex
+	models  []*genai.ModelInfo
+	expires time.Time
+}
+
+// List returns the Gemini models, using the
+// cached list if it hasn't expired yet.
+func (c *ModelCache) List(ctx context.Context, client *genai.Client) ([]*genai.ModelInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.models != nil && time.Now().Before(c.expires) {
+		return c.models, nil
+	}
+
+	var models []*genai.ModelInfo
+	iter := client.ListModels(ctx)
+	for {
+		info, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, info)
+	}
+	c.models = models
+	c.expires = time.Now().Add(modelsCacheTTL)
+	return models, nil
+}
+
+// Get returns the model with the given name. Models that are
+// not in the cached list, such as tuned models, are looked up.
+func (c *ModelCache) Get(ctx context.Context, client *genai.Client, name string) (*genai.ModelInfo, error) {
+	if models, err := c.List(ctx, client); err == nil {
+		for _, m := range models {
+			if m.Name == "models/"+strings.TrimPrefix(name, "models/") {
+				return m, nil
+			}
+		}
+	}
+	return client.GenerativeModel(name).Info(ctx)
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func TestModelCache(t *testing.T) {
+	var lists, gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1beta/models":
+			lists.Add(1)
+			w.Write([]byte(`{"models": [{"name": "models/gemini-1.5-flash"}, {"name": "models/text-embedding-004"}]}`))
+		case "/v1beta/models/gemini-exp":
+			gets.Add(1)
+			w.Write([]byte(`{"name": "models/gemini-exp"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found", "status": "NOT_FOUND"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey("test"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var c ModelCache
+	for i := 0; i < 2; i++ {
+		models, err := c.List(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(models) != 2 || models[0].Name != "models/gemini-1.5-flash" {
+			t.Errorf("List() = %v, want 2 models", models)
+		}
+	}
+	if got := lists.Load(); got != 1 {
+		t.Errorf("models listed %d times, want 1", got)
+	}
+
+	// Cached models are found with or without the "models/" prefix.
+	for _, name := range []string{"gemini-1.5-flash", "models/gemini-1.5-flash"} {
+		info, err := c.Get(ctx, client, name)
+		if err != nil {
+			t.Fatalf("Get(%q) failed: %v", name, err)
+		}
+		if info.Name != "models/gemini-1.5-flash" {
+			t.Errorf("Get(%q).Name = %q, want models/gemini-1.5-flash", name, info.Name)
+		}
+	}
+	if got := gets.Load(); got != 0 {
+		t.Errorf("cached models looked up %d times, want 0", got)
+	}
+
+	// Other models are looked up.
+	info, err := c.Get(ctx, client, "gemini-exp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "models/gemini-exp" || gets.Load() != 1 {
+		t.Errorf("Get(gemini-exp) = %q after %d lookups, want models/gemini-exp after 1", info.Name, gets.Load())
+	}
+	if _, err := c.Get(ctx, client, "unknown"); StatusCode(err) != http.StatusNotFound {
+		t.Errorf("Get(unknown) error = %v, want not found", err)
+	}
+
+	// The list is fetched again once it expires.
+	c.expires = time.Now()
+	if _, err := c.List(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if got := lists.Load(); got != 2 {
+		t.Errorf("models listed %d times after expiry, want 2", got)
+	}
+}
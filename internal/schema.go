@@ -0,0 +1,205 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ToGeminiSchema converts a JSON Schema into a Gemini schema.
+//
+// Gemini only understands a subset of OpenAPI 3.0 schemas. Keywords that
+// can't be expressed, such as $ref, oneOf or additionalProperties, are
+// reported as errors instead of being dropped, so the caller can reject
+// the request. Pure annotations like title or default are ignored.
+func ToGeminiSchema(v map[string]any) (*genai.Schema, error) {
+	return toGeminiSchema(v, "#")
+}
+
+func toGeminiSchema(v map[string]any, path string) (*genai.Schema, error) {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := &genai.Schema{}
+	for _, k := range keys {
+		value := v[k]
+		switch k {
+		case "type":
+			if err := setSchemaType(s, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		case "format":
+			format, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: format must be a string", path)
+			}
+			s.Format = format
+		case "description":
+			description, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: description must be a string", path)
+			}
+			s.Description = description
+		case "nullable":
+			nullable, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: nullable must be a boolean", path)
+			}
+			s.Nullable = s.Nullable || nullable
+		case "enum":
+			values, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: enum must be an array", path)
+			}
+			for _, e := range values {
+				str, ok := e.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: only string enum values are supported", path)
+				}
+				s.Enum = append(s.Enum, str)
+			}
+		case "const":
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: only string const values are supported", path)
+			}
+			s.Enum = []string{str}
+		case "items":
+			items, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: items must be a single schema", path)
+			}
+			schema, err := toGeminiSchema(items, path+"/items")
+			if err != nil {
+				return nil, err
+			}
+			s.Items = schema
+		case "properties":
+			props, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: properties must be an object", path)
+			}
+			s.Properties = make(map[string]*genai.Schema, len(props))
+			for name, prop := range props {
+				m, ok := prop.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("%s/properties/%s: schema must be an object", path, name)
+				}
+				schema, err := toGeminiSchema(m, path+"/properties/"+name)
+				if err != nil {
+					return nil, err
+				}
+				s.Properties[name] = schema
+			}
+		case "required":
+			required, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: required must be an array", path)
+			}
+			for _, r := range required {
+				name, ok := r.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: required must only contain strings", path)
+				}
+				s.Required = append(s.Required, name)
+			}
+		case "additionalProperties":
+			// Gemini never generates undeclared properties, so false
+			// is what it does anyway. Anything else can't be honored.
+			if allowed, ok := value.(bool); !ok || allowed {
+				return nil, fmt.Errorf("%s: additionalProperties is not supported by Gemini", path)
+			}
+		case "title", "default", "examples", "$schema", "$id", "$comment", "$defs", "definitions":
+			// Annotations that don't affect the generated output.
+		default:
+			return nil, fmt.Errorf("%s: %s is not supported by Gemini", path, k)
+		}
+	}
+
+	if s.Type == genai.TypeUnspecified {
+		switch {
+		case s.Properties != nil:
+			s.Type = genai.TypeObject
+		case s.Items != nil:
+			s.Type = genai.TypeArray
+		case s.Enum != nil:
+			s.Type = genai.TypeString
+		default:
+			return nil, fmt.Errorf("%s: schema has no type", path)
+		}
+	}
+	if s.Enum != nil && s.Type == genai.TypeString && s.Format == "" {
+		s.Format = "enum"
+	}
+	return s, nil
+}
+
+// setSchemaType sets the type of s from a JSON Schema type, which is
+// either a single type name or a list of them. The only list that can be
+// represented is a single type combined with "null".
+func setSchemaType(s *genai.Schema, v any) error {
+	var names []string
+	switch v := v.(type) {
+	case string:
+		names = []string{v}
+	case []any:
+		for _, n := range v {
+			name, ok := n.(string)
+			if !ok {
+				return fmt.Errorf("type must be a string or an array of strings")
+			}
+			names = append(names, name)
+		}
+	default:
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+
+	for _, name := range names {
+		var t genai.Type
+		switch name {
+		case "null":
+			s.Nullable = true
+			continue
+		case "string":
+			t = genai.TypeString
+		case "number":
+			t = genai.TypeNumber
+		case "integer":
+			t = genai.TypeInteger
+		case "boolean":
+			t = genai.TypeBoolean
+		case "array":
+			t = genai.TypeArray
+		case "object":
+			t = genai.TypeObject
+		default:
+			return fmt.Errorf("unknown type %q", name)
+		}
+		if s.Type != genai.TypeUnspecified {
+			return fmt.Errorf("multiple types are not supported by Gemini")
+		}
+		s.Type = t
+	}
+	if s.Type == genai.TypeUnspecified {
+		return fmt.Errorf("type null is not supported by Gemini")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestToGeminiSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		want    *genai.Schema
+		wantErr string
+	}{
+		{
+			name: "object",
+			schema: `{
+				"type": "object",
+				"title": "Weather",
+				"properties": {
+					"city": {"type": "string", "description": "City name"},
+					"unit": {"type": "string", "enum": ["celsius", "fahrenheit"]},
+					"days": {"type": ["integer", "null"]},
+					"tags": {"type": "array", "items": {"type": "string"}}
+				},
+				"required": ["city"],
+				"additionalProperties": false
+			}`,
+			want: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"city": {Type: genai.TypeString, Description: "City name"},
+					"unit": {Type: genai.TypeString, Format: "enum", Enum: []string{"celsius", "fahrenheit"}},
+					"days": {Type: genai.TypeInteger, Nullable: true},
+					"tags": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+				},
+				Required: []string{"city"},
+			},
+		},
+		{
+			name:   "inferred type",
+			schema: `{"properties": {"ok": {"type": "boolean"}}}`,
+			want: &genai.Schema{
+				Type:       genai.TypeObject,
+				Properties: map[string]*genai.Schema{"ok": {Type: genai.TypeBoolean}},
+			},
+		},
+		{
+			name:    "ref",
+			schema:  `{"type": "object", "properties": {"a": {"$ref": "#/$defs/A"}}}`,
+			wantErr: "#/properties/a: $ref is not supported",
+		},
+		{
+			name:    "oneOf",
+			schema:  `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`,
+			wantErr: "#: oneOf is not supported",
+		},
+		{
+			name:    "additionalProperties schema",
+			schema:  `{"type": "object", "additionalProperties": {"type": "string"}}`,
+			wantErr: "#: additionalProperties is not supported",
+		},
+		{
+			name:    "multiple types",
+			schema:  `{"type": ["string", "integer"]}`,
+			wantErr: "multiple types are not supported",
+		},
+		{
+			name:    "no type",
+			schema:  `{"description": "anything"}`,
+			wantErr: "#: schema has no type",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v map[string]any
+			if err := json.Unmarshal([]byte(tt.schema), &v); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ToGeminiSchema(v)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ToGeminiSchema() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ToGeminiSchema() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ToGeminiSchema() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/grpc/codes"
+)
+
+// StatusCode returns the HTTP status code to respond with
+// for an error returned by the Gemini API. Clients decide
+// whether to retry based on it, so it only ever returns
+// 400, 401, 403, 404, 429, 500 or 503.
+func StatusCode(err error) int {
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable
+	}
+
+	// The REST client already returns an *apierror.APIError. Parsing it
+	// again would lose the HTTP code, since it also looks like a gRPC status.
+	var ae *apierror.APIError
+	if !errors.As(err, &ae) {
+		var ok bool
+		if ae, ok = apierror.FromError(err); !ok {
+			return http.StatusInternalServerError
+		}
+	}
+	// Gemini reports invalid API keys as bad requests.
+	if ae.Reason() == "API_KEY_INVALID" {
+		return http.StatusUnauthorized
+	}
+	if code := ae.HTTPCode(); code > 0 {
+		switch code {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden,
+			http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return code
+		case http.StatusGatewayTimeout:
+			return http.StatusServiceUnavailable
+		}
+		if code >= 400 && code < 500 {
+			return http.StatusBadRequest
+		}
+		return http.StatusInternalServerError
+	}
+
+	switch ae.GRPCStatus().Code() {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "invalid api key",
+			err: &googleapi.Error{
+				Code: http.StatusBadRequest,
+				Body: `{"error": {"code": 400, "message": "API key not valid.", "status": "INVALID_ARGUMENT", "details": [{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "API_KEY_INVALID", "domain": "googleapis.com"}]}}`,
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "bad request",
+			err:  &googleapi.Error{Code: http.StatusBadRequest},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "wrapped not found",
+			err:  fmt.Errorf("generating: %w", &googleapi.Error{Code: http.StatusNotFound}),
+			want: http.StatusNotFound,
+		},
+		{
+			name: "rate limited",
+			err:  &googleapi.Error{Code: http.StatusTooManyRequests},
+			want: http.StatusTooManyRequests,
+		},
+		{
+			name: "rest client error",
+			err:  mustParseError(&googleapi.Error{Code: http.StatusServiceUnavailable}),
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "gateway timeout",
+			err:  &googleapi.Error{Code: http.StatusGatewayTimeout},
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "grpc permission denied",
+			err:  status.Error(codes.PermissionDenied, "denied"),
+			want: http.StatusForbidden,
+		},
+		{
+			name: "grpc unavailable",
+			err:  status.Error(codes.Unavailable, "unavailable"),
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name: "unknown",
+			err:  errors.New("boom"),
+			want: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusCode(tt.err); got != tt.want {
+				t.Errorf("StatusCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustParseError(err error) error {
+	ae, ok := apierror.FromError(err)
+	if !ok {
+		panic(fmt.Sprintf("failed to parse %v", err))
+	}
+	return ae
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+var taskTypes = map[string]genai.TaskType{
+	"RETRIEVAL_QUERY":     genai.TaskTypeRetrievalQuery,
+	"RETRIEVAL_DOCUMENT":  genai.TaskTypeRetrievalDocument,
+	"SEMANTIC_SIMILARITY": genai.TaskTypeSemanticSimilarity,
+	"CLASSIFICATION":      genai.TaskTypeClassification,
+	"CLUSTERING":          genai.TaskTypeClustering,
+	"QUESTION_ANSWERING":  genai.TaskTypeQuestionAnswering,
+	"FACT_VERIFICATION":   genai.TaskTypeFactVerification,
+}
+
+// ParseTaskType returns the embedding task type with the given
+// Gemini API name, such as RETRIEVAL_QUERY. Names are case
+// insensitive and an empty name is TaskTypeUnspecified.
+func ParseTaskType(name string) (genai.TaskType, error) {
+	if name == "" {
+		return genai.TaskTypeUnspecified, nil
+	}
+	t, ok := taskTypes[strings.ToUpper(name)]
+	if !ok {
+		return genai.TaskTypeUnspecified, fmt.Errorf("unknown task type %q", name)
+	}
+	return t, nil
+}
+
+// ParseTaskTypes parses a comma separated list of model=TASK_TYPE pairs,
+// which configures the default embedding task type of each model.
+func ParseTaskTypes(s string) (map[string]genai.TaskType, error) {
+	m := make(map[string]genai.TaskType)
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		model, name, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || model == "" {
+			return nil, fmt.Errorf("malformed task type %q, want model=TASK_TYPE", pair)
+		}
+		t, err := ParseTaskType(name)
+		if err != nil {
+			return nil, err
+		}
+		m[strings.TrimPrefix(model, "models/")] = t
+	}
+	return m, nil
+}
+
+// EmbeddingTaskType returns the task type to embed with. An explicit task
+// type takes precedence over the default configured for the model. Gemini
+// only accepts a title for RETRIEVAL_DOCUMENT, which a title implies.
+func EmbeddingTaskType(name, title, model string, defaults map[string]genai.TaskType) (genai.TaskType, error) {
+	t, err := ParseTaskType(name)
+	if err != nil {
+		return genai.TaskTypeUnspecified, err
+	}
+	if title != "" {
+		if t != genai.TaskTypeUnspecified && t != genai.TaskTypeRetrievalDocument {
+			return genai.TaskTypeUnspecified, fmt.Errorf("title is only supported with task type RETRIEVAL_DOCUMENT")
+		}
+		return genai.TaskTypeRetrievalDocument, nil
+	}
+	if t == genai.TaskTypeUnspecified {
+		t = defaults[strings.TrimPrefix(model, "models/")]
+	}
+	return t, nil
+}
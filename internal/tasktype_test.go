@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestParseTaskTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    map[string]genai.TaskType
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			want: map[string]genai.TaskType{},
+		},
+		{
+			name: "models",
+			s:    "text-embedding-004=RETRIEVAL_DOCUMENT, models/embedding-001=clustering",
+			want: map[string]genai.TaskType{
+				"text-embedding-004": genai.TaskTypeRetrievalDocument,
+				"embedding-001":      genai.TaskTypeClustering,
+			},
+		},
+		{
+			name:    "missing task type",
+			s:       "text-embedding-004",
+			wantErr: true,
+		},
+		{
+			name:    "unknown task type",
+			s:       "text-embedding-004=SEARCH",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTaskTypes(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTaskTypes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTaskTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddingTaskType(t *testing.T) {
+	defaults := map[string]genai.TaskType{"text-embedding-004": genai.TaskTypeRetrievalQuery}
+	tests := []struct {
+		name     string
+		taskType string
+		title    string
+		model    string
+		want     genai.TaskType
+		wantErr  bool
+	}{
+		{
+			name:  "unconfigured",
+			model: "embedding-001",
+			want:  genai.TaskTypeUnspecified,
+		},
+		{
+			name:  "default",
+			model: "models/text-embedding-004",
+			want:  genai.TaskTypeRetrievalQuery,
+		},
+		{
+			name:     "explicit",
+			taskType: "semantic_similarity",
+			model:    "text-embedding-004",
+			want:     genai.TaskTypeSemanticSimilarity,
+		},
+		{
+			name:  "title overrides default",
+			title: "Proxy",
+			model: "text-embedding-004",
+			want:  genai.TaskTypeRetrievalDocument,
+		},
+		{
+			name:     "title with other task type",
+			taskType: "RETRIEVAL_QUERY",
+			title:    "Proxy",
+			model:    "text-embedding-004",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EmbeddingTaskType(tt.taskType, tt.title, tt.model, defaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EmbeddingTaskType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("EmbeddingTaskType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ToFunctionDeclaration converts a function, whose parameters
+// are described by a JSON schema, into a Gemini declaration.
+func ToFunctionDeclaration(name, description string, parameters map[string]any) (*genai.FunctionDeclaration, error) {
+	decl := &genai.FunctionDeclaration{
+		Name:        name,
+		Description: description,
+	}
+	if len(parameters) == 0 {
+		return decl, nil
+	}
+	params, err := ToGeminiSchema(parameters)
+	if err != nil {
+		return nil, fmt.Errorf("parameters of function %q: %v", name, err)
+	}
+	// Gemini rejects objects without properties,
+	// it expects no parameters to be declared instead.
+	if params.Type != genai.TypeObject || len(params.Properties) > 0 {
+		decl.Parameters = params
+	}
+	return decl, nil
+}
+
+// ToFunctionResponse wraps the result of a tool call. Gemini expects
+// a JSON object, so anything else is reported under "content".
+func ToFunctionResponse(content string) map[string]any {
+	var v map[string]any
+	if err := json.Unmarshal([]byte(content), &v); err == nil && v != nil {
+		return v
+	}
+	return map[string]any{"content": content}
+}
+
+// ToConversation turns the contents of chat messages into a Gemini
+// system instruction and conversation. System contents are combined
+// into the system instruction. Gemini requires user and model turns
+// to alternate, so consecutive contents of the same role, such as the
+// results of parallel tool calls, are merged into one turn. The
+// conversation must end on a user turn, Gemini can't continue a
+// model turn.
+func ToConversation(contents []*genai.Content) (system *genai.Content, conversation []*genai.Content, err error) {
+	for _, content := range contents {
+		if content.Role == "system" {
+			if system == nil {
+				system = &genai.Content{Role: "system"}
+			}
+			system.Parts = append(system.Parts, content.Parts...)
+			continue
+		}
+		if n := len(conversation); n > 0 && conversation[n-1].Role == content.Role {
+			conversation[n-1].Parts = append(conversation[n-1].Parts, content.Parts...)
+			continue
+		}
+		conversation = append(conversation, content)
+	}
+	if len(conversation) == 0 {
+		return nil, nil, fmt.Errorf("at least one user message is required")
+	}
+	if conversation[len(conversation)-1].Role != "user" {
+		return nil, nil, fmt.Errorf("the last message must be a user or tool message, assistant prefill is not supported by Gemini")
+	}
+	return system, conversation, nil
+}
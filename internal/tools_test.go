@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestToFunctionDeclaration(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]any
+		want       *genai.Schema
+		wantErr    bool
+	}{
+		{
+			name: "no parameters",
+		},
+		{
+			name:       "object without properties",
+			parameters: map[string]any{"type": "object", "properties": map[string]any{}},
+		},
+		{
+			name: "object",
+			parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"city": map[string]any{"type": "string"}},
+			},
+			want: &genai.Schema{
+				Type:       genai.TypeObject,
+				Properties: map[string]*genai.Schema{"city": {Type: genai.TypeString}},
+			},
+		},
+		{
+			name:       "unsupported schema",
+			parameters: map[string]any{"oneOf": []any{map[string]any{"type": "string"}}},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToFunctionDeclaration("get_weather", "Gets the weather.", tt.parameters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToFunctionDeclaration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := &genai.FunctionDeclaration{Name: "get_weather", Description: "Gets the weather.", Parameters: tt.want}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ToFunctionDeclaration() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestToFunctionResponse(t *testing.T) {
+	tests := []struct {
+		content string
+		want    map[string]any
+	}{
+		{content: `{"celsius":20}`, want: map[string]any{"celsius": float64(20)}},
+		{content: "sunny", want: map[string]any{"content": "sunny"}},
+		{content: "[1,2]", want: map[string]any{"content": "[1,2]"}},
+		{content: "null", want: map[string]any{"content": "null"}},
+	}
+	for _, tt := range tests {
+		if got := ToFunctionResponse(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ToFunctionResponse(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestToConversation(t *testing.T) {
+	text := func(role string, s ...string) *genai.Content {
+		c := &genai.Content{Role: role}
+		for _, s := range s {
+			c.Parts = append(c.Parts, genai.Text(s))
+		}
+		return c
+	}
+	tests := []struct {
+		name             string
+		contents         []*genai.Content
+		wantSystem       *genai.Content
+		wantConversation []*genai.Content
+		wantErr          bool
+	}{
+		{
+			name: "conversation",
+			contents: []*genai.Content{
+				text("system", "Be brief."),
+				text("user", "Hi"),
+				text("system", "Answer in French."),
+				text("user", "How are you?"),
+				text("model", "Bien."),
+				text("user", "Bye"),
+			},
+			wantSystem: text("system", "Be brief.", "Answer in French."),
+			wantConversation: []*genai.Content{
+				text("user", "Hi", "How are you?"),
+				text("model", "Bien."),
+				text("user", "Bye"),
+			},
+		},
+		{
+			name:     "ends on model",
+			contents: []*genai.Content{text("user", "Hi"), text("model", "Hello")},
+			wantErr:  true,
+		},
+		{
+			name:     "only system",
+			contents: []*genai.Content{text("system", "Be brief.")},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system, conversation, err := ToConversation(tt.contents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToConversation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(system, tt.wantSystem) {
+				t.Errorf("ToConversation() system = %+v, want %+v", system, tt.wantSystem)
+			}
+			if !reflect.DeepEqual(conversation, tt.wantConversation) {
+				t.Errorf("ToConversation() conversation = %+v, want %+v", conversation, tt.wantConversation)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+)
+
+// alias is a local model name created with /api/create or /api/copy,
+// which points to a Gemini model with a system prompt, a template
+// and parameters of its own.
+type alias struct {
+	From       string    `json:"from"`
+	System     string    `json:"system,omitempty"`
+	Template   string    `json:"template,omitempty"`
+	Parameters Options   `json:"parameters"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// aliasStore keeps the model aliases, and saves them as
+// a JSON object of aliases by name to its file, if any.
+// Stored aliases are never modified, only replaced.
+type aliasStore struct {
+	path string
+
+	mu      sync.Mutex
+	aliases map[string]*alias
+}
+
+// newAliasStore loads the aliases saved to path. If path is empty,
+// the aliases are kept in memory only.
+func newAliasStore(path string) (*aliasStore, error) {
+	s := &aliasStore{path: path, aliases: make(map[string]*alias)}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.aliases); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *aliasStore) get(name string) (*alias, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.aliases[name]
+	return a, ok
+}
+
+// all returns the aliases by name.
+func (s *aliasStore) all() map[string]*alias {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	aliases := make(map[string]*alias, len(s.aliases))
+	for name, a := range s.aliases {
+		aliases[name] = a
+	}
+	return aliases
+}
+
+// put creates or replaces an alias.
+func (s *aliasStore) put(name string, a *alias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.aliases[name]
+	s.aliases[name] = a
+	if err := s.save(); err != nil {
+		if ok {
+			s.aliases[name] = old
+		} else {
+			delete(s.aliases, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// delete deletes an alias, it returns false if there is none.
+func (s *aliasStore) delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.aliases[name]
+	if !ok {
+		return false, nil
+	}
+	delete(s.aliases, name)
+	if err := s.save(); err != nil {
+		s.aliases[name] = old
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the aliases to the file. The file is replaced
+// at once, so it is never left half written.
+func (s *aliasStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// resolveModel returns the alias of an Ollama model name. Names that
+// aren't aliases are Gemini models, which get an alias of their own.
+func (h *handlers) resolveModel(name string) *alias {
+	name = modelName(name)
+	if a, ok := h.aliases.get(name); ok {
+		return a
+	}
+	return &alias{From: name}
+}
+
+func (h *handlers) createHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req CreateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+	if err := validateAliasName(name); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	mf := &modelfile{Parameters: make(map[string]any)}
+	if req.Modelfile != "" {
+		if mf, err = parseModelfile(req.Modelfile); err != nil {
+			internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid modelfile: %v", err)
+			return
+		}
+	}
+	if req.From != "" {
+		mf.From = req.From
+	}
+	if req.System != "" {
+		mf.System = req.System
+	}
+	if req.Template != "" {
+		mf.Template = req.Template
+	}
+	for k, v := range req.Parameters {
+		mf.Parameters[k] = v
+	}
+	if mf.From == "" {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "from is required")
+		return
+	}
+	if mf.Template != "" {
+		if _, err := renderTemplate(mf.Template, templateValues{}); err != nil {
+			internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid template: %v", err)
+			return
+		}
+	}
+	options, err := toOptions(mf.Parameters)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// Aliases of aliases point to the Gemini model
+	// and start off with the settings of their base.
+	base := modelName(mf.From)
+	a := &alias{
+		From:       base,
+		System:     mf.System,
+		Template:   mf.Template,
+		Parameters: options,
+		ModifiedAt: time.Now(),
+	}
+	if b, ok := h.aliases.get(base); ok {
+		a.From = b.From
+		if a.System == "" {
+			a.System = b.System
+		}
+		if a.Template == "" {
+			a.Template = b.Template
+		}
+		a.Parameters = mergeOptions(b.Parameters, options)
+	} else if _, err := h.models.Get(r.Context(), h.client, base); err != nil {
+		internal.ErrorHandler(w, r, internal.StatusCode(err), "failed to get model %q: %v", base, err)
+		return
+	}
+
+	if err := h.aliases.put(modelName(name), a); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to save model %q: %v", name, err)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(&ProgressResponse{Status: "success"}); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode create response: %v", err)
+		return
+	}
+}
+
+func (h *handlers) copyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req CopyRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+	if req.Source == "" {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "source is required")
+		return
+	}
+	if err := validateAliasName(req.Destination); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// Copying a Gemini model creates an alias without settings.
+	a := *h.resolveModel(req.Source)
+	if _, ok := h.aliases.get(modelName(req.Source)); !ok {
+		if _, err := h.models.Get(r.Context(), h.client, a.From); err != nil {
+			internal.ErrorHandler(w, r, internal.StatusCode(err), "failed to get model %q: %v", req.Source, err)
+			return
+		}
+	}
+	a.ModifiedAt = time.Now()
+	if err := h.aliases.put(modelName(req.Destination), &a); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to save model %q: %v", req.Destination, err)
+		return
+	}
+}
+
+func (h *handlers) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req DeleteRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+
+	// Only aliases can be deleted, Gemini models are not the proxy's.
+	ok, err := h.aliases.delete(modelName(name))
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to delete model %q: %v", name, err)
+		return
+	}
+	if !ok {
+		internal.ErrorHandler(w, r, http.StatusNotFound, "model %q not found", name)
+		return
+	}
+}
+
+// validateAliasName checks that name can be used as a model name.
+func validateAliasName(name string) error {
+	if name == "" {
+		return fmt.Errorf("model is required")
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r <= ' ' }) {
+		return fmt.Errorf("model %q must not contain spaces", name)
+	}
+	return nil
+}
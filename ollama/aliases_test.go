@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_aliasStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.json")
+	s, err := newAliasStore(path)
+	if err != nil {
+		t.Fatalf("newAliasStore() error = %v", err)
+	}
+	if err := s.put("summarizer", &alias{From: "gemini-1.5-flash", System: "Summarize."}); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+	if err := s.put("pirate", &alias{From: "gemini-1.5-pro"}); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+	if ok, err := s.delete("pirate"); !ok || err != nil {
+		t.Fatalf("delete() = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := s.delete("pirate"); ok || err != nil {
+		t.Fatalf("delete() of a deleted alias = %v, %v, want false, nil", ok, err)
+	}
+
+	// The aliases are loaded again from the file.
+	s, err = newAliasStore(path)
+	if err != nil {
+		t.Fatalf("newAliasStore() error = %v", err)
+	}
+	if got := s.all(); len(got) != 1 || got["summarizer"].System != "Summarize." {
+		t.Errorf("all() = %v, want the summarizer alias only", got)
+	}
+}
+
+func Test_aliasHandlers(t *testing.T) {
+	s, err := newAliasStore("")
+	if err != nil {
+		t.Fatalf("newAliasStore() error = %v", err)
+	}
+	if err := s.put("summarizer", &alias{From: "gemini-1.5-flash", System: "Summarize."}); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+	h := &handlers{aliases: s}
+
+	// Aliases of aliases don't need Gemini to be created.
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		method   string
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "create",
+			handler:  h.createHandler,
+			method:   http.MethodPost,
+			body:     `{"model": "brief:latest", "modelfile": "FROM summarizer\nPARAMETER num_predict 100"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "create with invalid parameter",
+			handler:  h.createHandler,
+			method:   http.MethodPost,
+			body:     `{"model": "brief", "modelfile": "FROM summarizer\nPARAMETER creativity 1"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "create without from",
+			handler:  h.createHandler,
+			method:   http.MethodPost,
+			body:     `{"model": "brief", "modelfile": "SYSTEM Be brief."}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "copy",
+			handler:  h.copyHandler,
+			method:   http.MethodPost,
+			body:     `{"source": "brief", "destination": "terse"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "copy to an invalid name",
+			handler:  h.copyHandler,
+			method:   http.MethodPost,
+			body:     `{"source": "brief", "destination": "very terse"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "delete",
+			handler:  h.deleteHandler,
+			method:   http.MethodDelete,
+			body:     `{"model": "terse"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "delete a deleted alias",
+			handler:  h.deleteHandler,
+			method:   http.MethodDelete,
+			body:     `{"model": "terse"}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "delete with the wrong method",
+			handler:  h.deleteHandler,
+			method:   http.MethodPost,
+			body:     `{"model": "brief"}`,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handler(rec, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantCode {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.wantCode, rec.Body)
+		}
+	}
+
+	a := h.resolveModel("brief:latest")
+	if a.From != "gemini-1.5-flash" || a.System != "Summarize." || a.Parameters.NumPredict == nil || *a.Parameters.NumPredict != 100 {
+		t.Errorf("resolveModel() = %+v, want the summarizer settings with num_predict 100", a)
+	}
+	if a := h.resolveModel("gemini-1.5-pro:latest"); a.From != "gemini-1.5-pro" || a.System != "" {
+		t.Errorf("resolveModel() = %+v, want gemini-1.5-pro without settings", a)
+	}
+}
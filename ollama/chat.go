@@ -0,0 +1,294 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+func (h *handlers) chatHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req ChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+
+	mimeType, schema, err := toGeminiFormat(req.Format)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid format: %v", err)
+		return
+	}
+
+	system, contents, err := h.toGeminiContents(req.Messages)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid messages: %v", err)
+		return
+	}
+	tools, err := toGeminiTools(req.Tools)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid tools: %v", err)
+		return
+	}
+
+	// System messages and request options override the system
+	// prompt and parameters of aliases. Chats have no template,
+	// the messages are sent to Gemini as they are.
+	a := h.resolveModel(req.Model)
+	if system == nil && a.System != "" {
+		system = &genai.Content{Role: "system", Parts: []genai.Part{genai.Text(a.System)}}
+	}
+	options := mergeOptions(a.Parameters, req.Options)
+
+	model := h.client.GenerativeModel(a.From)
+	model.GenerationConfig = toGenerationConfig(options)
+	reportIgnoredOptions(w, options)
+	model.GenerationConfig.ResponseMIMEType = mimeType
+	model.GenerationConfig.ResponseSchema = schema
+	model.SystemInstruction = system
+	model.Tools = tools
+
+	// Gemini takes the last turn separately from the history.
+	chat := model.StartChat()
+	chat.History = contents[:len(contents)-1]
+	lastParts := contents[len(contents)-1].Parts
+
+	// Ollama streams unless told otherwise.
+	if req.Stream == nil || *req.Stream {
+		stats := newStreamStats(start)
+		iter := chat.SendMessageStream(r.Context(), lastParts...)
+		streamingChatHandler(w, r, req.Model, stats, iter)
+		return
+	}
+
+	requested := time.Now()
+	gresp, err := chat.SendMessage(r.Context(), lastParts...)
+	if err != nil {
+		internal.ErrorHandler(w, r, internal.StatusCode(err), "failed to generate content: %v", err)
+		return
+	}
+	end := time.Now()
+	if len(gresp.Candidates) == 0 {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "no candidates returned")
+		return
+	}
+	c := gresp.Candidates[0]
+	message, err := toOllamaMessage(c)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	resp := &ChatResponse{
+		Model:      req.Model,
+		CreatedAt:  end,
+		Message:    message,
+		Done:       true,
+		DoneReason: toDoneReason(c.FinishReason),
+		Metrics:    responseMetrics(start, requested, end, gresp.UsageMetadata),
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode chat response: %v", err)
+		return
+	}
+}
+
+// streamingChatHandler sends a message line for every chunk
+// Gemini streams back, followed by a done line with the reason
+// generation stopped, token counts and timings. Gemini streams
+// function calls whole, so each tool call is sent in one line.
+func streamingChatHandler(w http.ResponseWriter, r *http.Request, name string, stats *streamStats, iter contentStream) {
+	lw := newLineWriter(w)
+	for {
+		gresp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			lw.writeError(w, r, err, "failed to stream response")
+			return
+		}
+		c := stats.add(gresp)
+		if c == nil {
+			continue
+		}
+		message, err := toOllamaMessage(c)
+		if err != nil {
+			lw.writeError(w, r, err, "failed to stream response")
+			return
+		}
+		if message.Content == "" && len(message.ToolCalls) == 0 {
+			continue
+		}
+		if err := lw.write(&ChatResponse{
+			Model:     name,
+			CreatedAt: time.Now(),
+			Message:   message,
+		}); err != nil {
+			log.Printf("Error writing response: %v", err)
+			return
+		}
+	}
+
+	end := time.Now()
+	if err := lw.write(&ChatResponse{
+		Model:      name,
+		CreatedAt:  end,
+		Message:    Message{Role: "assistant"},
+		Done:       true,
+		DoneReason: toDoneReason(stats.finishReason),
+		Metrics:    stats.metrics(end),
+	}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// toGeminiContents converts Ollama chat messages into a Gemini system
+// instruction and conversation, as internal.ToConversation does.
+func (h *handlers) toGeminiContents(messages []Message) (system *genai.Content, contents []*genai.Content, err error) {
+	var toolCalls []string
+	contents = make([]*genai.Content, 0, len(messages))
+	for i, m := range messages {
+		content, err := h.toGeminiContent(m, &toolCalls)
+		if err != nil {
+			return nil, nil, fmt.Errorf("messages[%d]: %v", i, err)
+		}
+		contents = append(contents, content)
+	}
+	return internal.ToConversation(contents)
+}
+
+// toGeminiContent converts an Ollama chat message into Gemini content.
+// toolCalls holds the names of the tool calls of the last assistant
+// message that haven't been responded to. Older clients don't name the
+// tool in tool messages, these respond to the calls in order.
+func (h *handlers) toGeminiContent(m Message, toolCalls *[]string) (*genai.Content, error) {
+	var role string
+	switch m.Role {
+	case "system":
+		role = "system"
+	case "user":
+		role = "user"
+	case "assistant":
+		role = "model"
+	case "tool":
+		name := m.ToolName
+		if name == "" {
+			if len(*toolCalls) == 0 {
+				return nil, fmt.Errorf("tool message doesn't respond to any tool call, set tool_name")
+			}
+			name = (*toolCalls)[0]
+		}
+		if len(*toolCalls) > 0 {
+			*toolCalls = (*toolCalls)[1:]
+		}
+		// Gemini expects function responses in user turns.
+		return &genai.Content{
+			Role: "user",
+			Parts: []genai.Part{genai.FunctionResponse{
+				Name:     name,
+				Response: internal.ToFunctionResponse(m.Content),
+			}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown role %q", m.Role)
+	}
+
+	images, err := h.toGeminiImages(m.Images)
+	if err != nil {
+		return nil, err
+	}
+	var parts []genai.Part
+	if m.Content != "" || len(images) > 0 || len(m.ToolCalls) == 0 {
+		parts = withImages(m.Content, images)
+	}
+	*toolCalls = nil
+	for _, call := range m.ToolCalls {
+		parts = append(parts, genai.FunctionCall{
+			Name: call.Function.Name,
+			Args: call.Function.Arguments,
+		})
+		*toolCalls = append(*toolCalls, call.Function.Name)
+	}
+	return &genai.Content{Role: role, Parts: parts}, nil
+}
+
+// toGeminiTools converts Ollama tools into Gemini function declarations.
+func toGeminiTools(tools []Tool) ([]*genai.Tool, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+	decls := make([]*genai.FunctionDeclaration, 0, len(tools))
+	for _, t := range tools {
+		if t.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type %q", t.Type)
+		}
+		decl, err := internal.ToFunctionDeclaration(t.Function.Name, t.Function.Description, t.Function.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		decls = append(decls, decl)
+	}
+	return []*genai.Tool{{FunctionDeclarations: decls}}, nil
+}
+
+// toOllamaMessage converts a candidate into an assistant message.
+// Function calls predicted by Gemini become tool calls.
+func toOllamaMessage(c *genai.Candidate) (Message, error) {
+	m := Message{Role: "assistant"}
+	if c.Content == nil {
+		return m, nil
+	}
+	var b strings.Builder
+	for _, part := range c.Content.Parts {
+		switch v := part.(type) {
+		case genai.Text:
+			b.WriteString(string(v))
+		case genai.FunctionCall:
+			args := v.Args
+			if args == nil {
+				args = map[string]any{}
+			}
+			m.ToolCalls = append(m.ToolCalls, ToolCall{
+				Function: ToolCallFunction{
+					Index:     len(m.ToolCalls),
+					Name:      v.Name,
+					Arguments: args,
+				},
+			})
+		default:
+			return Message{}, fmt.Errorf("unsupported part type: %T", v)
+		}
+	}
+	m.Content = b.String()
+	return m, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func Test_toGeminiContents(t *testing.T) {
+	h := &handlers{maxImageSize: defaultMaxImageSize}
+	tests := []struct {
+		name         string
+		messages     []Message
+		wantSystem   *genai.Content
+		wantContents []*genai.Content
+		wantErr      bool
+	}{
+		{
+			name: "conversation",
+			messages: []Message{
+				{Role: "system", Content: "Be brief."},
+				{Role: "user", Content: "Hi"},
+				{Role: "assistant", Content: "Hello"},
+				{Role: "user", Content: "What's the weather in Paris?"},
+				{Role: "tool", ToolName: "get_weather", Content: `{"celsius":20}`},
+			},
+			wantSystem: &genai.Content{
+				Role:  "system",
+				Parts: []genai.Part{genai.Text("Be brief.")},
+			},
+			wantContents: []*genai.Content{
+				{Role: "user", Parts: []genai.Part{genai.Text("Hi")}},
+				{Role: "model", Parts: []genai.Part{genai.Text("Hello")}},
+				{Role: "user", Parts: []genai.Part{
+					genai.Text("What's the weather in Paris?"),
+					genai.FunctionResponse{Name: "get_weather", Response: map[string]any{"celsius": float64(20)}},
+				}},
+			},
+		},
+		{
+			name: "tool calls",
+			messages: []Message{
+				{Role: "user", Content: "What's the weather in Paris and Rome?"},
+				{Role: "assistant", ToolCalls: []ToolCall{
+					{Function: ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "Paris"}}},
+					{Function: ToolCallFunction{Index: 1, Name: "get_time", Arguments: map[string]any{"city": "Rome"}}},
+				}},
+				{Role: "tool", Content: "sunny"},
+				{Role: "tool", Content: "noon"},
+			},
+			wantContents: []*genai.Content{
+				{Role: "user", Parts: []genai.Part{genai.Text("What's the weather in Paris and Rome?")}},
+				{Role: "model", Parts: []genai.Part{
+					genai.FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Paris"}},
+					genai.FunctionCall{Name: "get_time", Args: map[string]any{"city": "Rome"}},
+				}},
+				{Role: "user", Parts: []genai.Part{
+					genai.FunctionResponse{Name: "get_weather", Response: map[string]any{"content": "sunny"}},
+					genai.FunctionResponse{Name: "get_time", Response: map[string]any{"content": "noon"}},
+				}},
+			},
+		},
+		{
+			name: "ends on assistant",
+			messages: []Message{
+				{Role: "user", Content: "Hi"},
+				{Role: "assistant", Content: "Hello"},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "only system",
+			messages: []Message{{Role: "system", Content: "Be brief."}},
+			wantErr:  true,
+		},
+		{
+			name:     "tool without call",
+			messages: []Message{{Role: "tool", Content: "sunny"}},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown role",
+			messages: []Message{{Role: "narrator", Content: "Once upon a time"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system, contents, err := h.toGeminiContents(tt.messages)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toGeminiContents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(system, tt.wantSystem) {
+				t.Errorf("toGeminiContents() system = %+v, want %+v", system, tt.wantSystem)
+			}
+			if !reflect.DeepEqual(contents, tt.wantContents) {
+				t.Errorf("toGeminiContents() contents = %+v, want %+v", contents, tt.wantContents)
+			}
+		})
+	}
+}
+
+func Test_toOllamaMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *genai.Candidate
+		want Message
+	}{
+		{
+			name: "text",
+			c:    &genai.Candidate{Content: &genai.Content{Parts: []genai.Part{genai.Text("Hello")}}},
+			want: Message{Role: "assistant", Content: "Hello"},
+		},
+		{
+			name: "tool calls",
+			c: &genai.Candidate{Content: &genai.Content{Parts: []genai.Part{
+				genai.FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Paris"}},
+				genai.FunctionCall{Name: "get_time"},
+			}}},
+			want: Message{Role: "assistant", ToolCalls: []ToolCall{
+				{Function: ToolCallFunction{Name: "get_weather", Arguments: map[string]any{"city": "Paris"}}},
+				{Function: ToolCallFunction{Index: 1, Name: "get_time", Arguments: map[string]any{}}},
+			}},
+		},
+		{
+			name: "no content",
+			c:    &genai.Candidate{FinishReason: genai.FinishReasonSafety},
+			want: Message{Role: "assistant"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toOllamaMessage(tt.c)
+			if err != nil {
+				t.Fatalf("toOllamaMessage() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toOllamaMessage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	defaultContextTTL      = 30 * time.Minute
+	defaultMaxContexts     = 1000
+	defaultMaxContextBytes = 256 << 20
+)
+
+// contextStore keeps the conversations of /api/generate. Ollama returns
+// the tokens of a conversation as its context, which clients send back
+// to continue it. Gemini tokens can't be sent back, so the conversation
+// is stored instead, and the context is an opaque key to it.
+//
+// Conversations expire once they weren't used for the TTL. If there are
+// too many, or they take more than maxBytes, the least recently used ones
+// are dropped. A conversation larger than maxBytes isn't stored at all.
+//
+// The turns of a conversation are shared by the contexts of its turns,
+// but counted for each of them, so images sent early in a long
+// conversation count many times over. The earlier contexts of a
+// conversation are usually the least recently used, so they are
+// dropped first.
+type contextStore struct {
+	ttl      time.Duration
+	max      int
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[contextKey]*list.Element
+	lru     *list.List // of *contextEntry, most recently used first
+	size    int64      // of all entries
+}
+
+type contextKey [16]byte
+
+type contextEntry struct {
+	key     contextKey
+	history []*genai.Content
+	size    int64
+	expires time.Time
+}
+
+func newContextStore(ttl time.Duration, max int, maxBytes int64) *contextStore {
+	if ttl <= 0 {
+		ttl = defaultContextTTL
+	}
+	if max <= 0 {
+		max = defaultMaxContexts
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxContextBytes
+	}
+	return &contextStore{
+		ttl:      ttl,
+		max:      max,
+		maxBytes: maxBytes,
+		entries:  make(map[contextKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// save stores a conversation and returns its context, or nil
+// if the conversation is too large to be stored.
+// Conversations are never changed once stored, every
+// turn of a conversation is saved under a new context.
+func (s *contextStore) save(history []*genai.Content) []int {
+	size := historySize(history)
+	if size > s.maxBytes {
+		return nil
+	}
+	var key contextKey
+	if _, err := rand.Read(key[:]); err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	s.entries[key] = s.lru.PushFront(&contextEntry{
+		key:     key,
+		history: slices.Clip(history),
+		size:    size,
+		expires: time.Now().Add(s.ttl),
+	})
+	s.size += size
+	for s.lru.Len() > s.max || s.size > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+	return encodeContext(key)
+}
+
+// load returns the conversation of a context, or false if
+// the context is unknown or the conversation expired.
+// The history must not be modified, only appended to.
+func (s *contextStore) load(context []int) ([]*genai.Content, bool) {
+	key, ok := decodeContext(context)
+	if !ok {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(time.Now())
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*contextEntry)
+	entry.expires = time.Now().Add(s.ttl)
+	s.lru.MoveToFront(e)
+	return entry.history, true
+}
+
+// evict removes the conversations that expired before now.
+func (s *contextStore) evict(now time.Time) {
+	for e := s.lru.Back(); e != nil && e.Value.(*contextEntry).expires.Before(now); e = s.lru.Back() {
+		s.remove(e)
+	}
+}
+
+func (s *contextStore) remove(e *list.Element) {
+	entry := s.lru.Remove(e).(*contextEntry)
+	delete(s.entries, entry.key)
+	s.size -= entry.size
+}
+
+// historySize approximates the memory a conversation takes by the
+// size of its texts and inline data, which is most of it.
+func historySize(history []*genai.Content) int64 {
+	var size int64
+	for _, c := range history {
+		for _, p := range c.Parts {
+			switch p := p.(type) {
+			case genai.Text:
+				size += int64(len(p))
+			case genai.Blob:
+				size += int64(len(p.Data))
+			}
+		}
+	}
+	return size
+}
+
+// encodeContext encodes a key as the integers of an Ollama context.
+func encodeContext(key contextKey) []int {
+	context := make([]int, len(key)/2)
+	for i := range context {
+		context[i] = int(binary.BigEndian.Uint16(key[2*i:]))
+	}
+	return context
+}
+
+func decodeContext(context []int) (contextKey, bool) {
+	var key contextKey
+	if len(context) != len(key)/2 {
+		return key, false
+	}
+	for i, v := range context {
+		if v < 0 || v > math.MaxUint16 {
+			return key, false
+		}
+		binary.BigEndian.PutUint16(key[2*i:], uint16(v))
+	}
+	return key, true
+}
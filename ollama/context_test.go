@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func Test_contextStore(t *testing.T) {
+	history := []*genai.Content{
+		genai.NewUserContent(genai.Text("Hi")),
+		{Role: "model", Parts: []genai.Part{genai.Text("Hello")}},
+	}
+
+	s := newContextStore(time.Hour, 2, 0)
+	first := s.save(history)
+	got, ok := s.load(first)
+	if !ok || !reflect.DeepEqual(got, history) {
+		t.Fatalf("load() = %v, %v, want %v, true", got, ok, history)
+	}
+
+	// Continuing a conversation must not change the stored one.
+	_ = append(got, genai.NewUserContent(genai.Text("Bye")))
+	if got, _ := s.load(first); len(got) != len(history) {
+		t.Errorf("load() returned %d contents after append, want %d", len(got), len(history))
+	}
+
+	// The least recently used conversation is dropped.
+	second := s.save(history)
+	s.load(first)
+	s.save(history)
+	if _, ok := s.load(second); ok {
+		t.Errorf("load() found the least recently used context")
+	}
+	if _, ok := s.load(first); !ok {
+		t.Errorf("load() didn't find a recently used context")
+	}
+
+	if _, ok := s.load([]int{1, 2, 3}); ok {
+		t.Errorf("load() found a malformed context")
+	}
+}
+
+func Test_contextStoreTTL(t *testing.T) {
+	s := newContextStore(time.Millisecond, 10, 0)
+	context := s.save([]*genai.Content{genai.NewUserContent(genai.Text("Hi"))})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := s.load(context); ok {
+		t.Errorf("load() found an expired context")
+	}
+}
+
+func Test_contextStoreBytes(t *testing.T) {
+	image := []*genai.Content{genai.NewUserContent(genai.Text("What is this?"), genai.ImageData("png", make([]byte, 100)))}
+	s := newContextStore(time.Hour, 10, 250)
+
+	// Conversations are dropped once they take more than the budget.
+	first := s.save(image)
+	second := s.save(image)
+	s.save(image)
+	if _, ok := s.load(first); ok {
+		t.Errorf("load() found a context over the byte budget")
+	}
+	if _, ok := s.load(second); !ok {
+		t.Errorf("load() didn't find a context within the byte budget")
+	}
+
+	// A conversation larger than the budget isn't stored.
+	if context := s.save([]*genai.Content{image[0], image[0], image[0]}); context != nil {
+		t.Errorf("save() = %v for a conversation over the byte budget, want nil", context)
+	}
+	if _, ok := s.load(second); !ok {
+		t.Errorf("load() didn't find a context after saving an oversized one")
+	}
+}
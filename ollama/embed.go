@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+)
+
+func (h *handlers) embedHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req EmbedRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+
+	name := h.resolveModel(req.Model).From
+	taskType, err := internal.EmbeddingTaskType(req.TaskType, req.Title, name, h.taskTypes)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid task_type: %v", err)
+		return
+	}
+
+	model := h.client.EmbeddingModel(name)
+	model.TaskType = taskType
+	embeddings, err := internal.BatchEmbed(r.Context(), model, req.Title, req.Input)
+	if err != nil {
+		internal.ErrorHandler(w, r, internal.StatusCode(err), "failed to create embedding: %v", err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&EmbedResponse{
+		Model:      req.Model,
+		Embeddings: embeddings,
+	}); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode embeddings response: %v", err)
+		return
+	}
+}
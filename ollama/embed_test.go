@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func Test_embedHandler(t *testing.T) {
+	// Gemini knows no models.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": 404, "message": "model not found", "status": "NOT_FOUND"}}`))
+	}))
+	defer srv.Close()
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	aliases, err := newAliasStore("")
+	if err != nil {
+		t.Fatalf("newAliasStore() error = %v", err)
+	}
+	h := &handlers{client: client, aliases: aliases}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "embed malformed",
+			handler:  h.embedHandler,
+			body:     `{"model": `,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "embed with an unknown model",
+			handler:  h.embedHandler,
+			body:     `{"model": "unknown", "input": ["hello"]}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "generate malformed",
+			handler:  h.generateHandler,
+			body:     `{"model": `,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.wantCode, rec.Body)
+		}
+	}
+}
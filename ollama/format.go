@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// toGeminiFormat returns the response MIME type and schema Gemini needs
+// to honor an Ollama format, which is either "json" or a JSON schema.
+// Without a format, the MIME type is left empty.
+func toGeminiFormat(format json.RawMessage) (string, *genai.Schema, error) {
+	if len(format) == 0 || string(format) == "null" {
+		return "", nil, nil
+	}
+	var name string
+	if err := json.Unmarshal(format, &name); err == nil {
+		switch name {
+		case "":
+			return "", nil, nil
+		case "json":
+			return "application/json", nil, nil
+		default:
+			return "", nil, fmt.Errorf("unsupported format %q", name)
+		}
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(format, &schema); err != nil {
+		return "", nil, fmt.Errorf("format must be \"json\" or a JSON schema")
+	}
+	s, err := internal.ToGeminiSchema(schema)
+	if err != nil {
+		return "", nil, err
+	}
+	return "application/json", s, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func Test_toGeminiFormat(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		wantMIMEType string
+		wantSchema   *genai.Schema
+		wantErr      bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:   "empty",
+			format: `""`,
+		},
+		{
+			name:         "json",
+			format:       `"json"`,
+			wantMIMEType: "application/json",
+		},
+		{
+			name:         "schema",
+			format:       `{"type": "object", "properties": {"age": {"type": "integer"}}, "required": ["age"]}`,
+			wantMIMEType: "application/json",
+			wantSchema: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"age": {Type: genai.TypeInteger},
+				},
+				Required: []string{"age"},
+			},
+		},
+		{
+			name:    "unknown format",
+			format:  `"yaml"`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported schema",
+			format:  `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "not a schema",
+			format:  `[1, 2]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mimeType, schema, err := toGeminiFormat(json.RawMessage(tt.format))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toGeminiFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if mimeType != tt.wantMIMEType {
+				t.Errorf("toGeminiFormat() MIME type = %q, want %q", mimeType, tt.wantMIMEType)
+			}
+			if !reflect.DeepEqual(schema, tt.wantSchema) {
+				t.Errorf("toGeminiFormat() schema = %+v, want %+v", schema, tt.wantSchema)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+func (h *handlers) generateHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req GenerateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+
+	if req.Raw && (req.System != "" || req.Template != "" || req.Suffix != "" || len(req.Context) > 0) {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "raw mode does not support system, template, suffix or context")
+		return
+	}
+
+	mimeType, schema, err := toGeminiFormat(req.Format)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid format: %v", err)
+		return
+	}
+
+	// Request options override the parameters of aliases, and so do
+	// the system prompt and template, which raw prompts go without.
+	a := h.resolveModel(req.Model)
+	options := mergeOptions(a.Parameters, req.Options)
+	if !req.Raw {
+		if req.System == "" {
+			req.System = a.System
+		}
+		if req.Template == "" {
+			req.Template = a.Template
+		}
+	}
+
+	model := h.client.GenerativeModel(a.From)
+	model.GenerationConfig = toGenerationConfig(options)
+	reportIgnoredOptions(w, options)
+	model.GenerationConfig.ResponseMIMEType = mimeType
+	model.GenerationConfig.ResponseSchema = schema
+
+	system, prompt := req.System, req.Prompt
+	var fim *internal.FillInTheMiddleStripper
+	switch {
+	case req.Raw:
+		// The prompt is sent as is.
+	case req.Template != "":
+		// The template decides where the system prompt goes,
+		// Gemini only gets the rendered prompt.
+		prompt, err = renderTemplate(req.Template, templateValues{
+			System: req.System,
+			Prompt: req.Prompt,
+			Suffix: req.Suffix,
+		})
+		if err != nil {
+			internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid template: %v", err)
+			return
+		}
+		system = ""
+	case req.Suffix != "":
+		// Gemini has no native fill-in-the-middle, it is asked
+		// for the middle and anything it repeats is stripped.
+		var instruction string
+		instruction, prompt = internal.FillInTheMiddle(req.Prompt, req.Suffix)
+		system = strings.TrimSpace(system + "\n\n" + instruction)
+		fim = internal.NewFillInTheMiddleStripper(req.Prompt, req.Suffix)
+	}
+	if system != "" {
+		model.SystemInstruction = &genai.Content{
+			Role:  "system",
+			Parts: []genai.Part{genai.Text(system)},
+		}
+	}
+	images, err := h.toGeminiImages(req.Images)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "invalid image: %v", err)
+		return
+	}
+	parts := withImages(prompt, images)
+
+	// The conversation is continued as a chat,
+	// which adds the response to its history.
+	chat := model.StartChat()
+	if len(req.Context) > 0 {
+		history, ok := h.contexts.load(req.Context)
+		if !ok {
+			log.Printf("Ignoring unknown or expired context")
+		}
+		chat.History = history
+	}
+
+	// Ollama streams unless told otherwise.
+	if req.Stream == nil || *req.Stream {
+		stats := newStreamStats(start)
+		iter := chat.SendMessageStream(r.Context(), parts...)
+		// The prompt is in the history now, the response
+		// is added once it is streamed.
+		h.streamingGenerateHandler(w, r, &req, stats, chat.History, iter, fim)
+		return
+	}
+
+	requested := time.Now()
+	gresp, err := chat.SendMessage(r.Context(), parts...)
+	if err != nil {
+		internal.ErrorHandler(w, r, internal.StatusCode(err), "failed to generate content: %v", err)
+		return
+	}
+	end := time.Now()
+	if len(gresp.Candidates) == 0 {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "no candidates returned")
+		return
+	}
+
+	c := gresp.Candidates[0]
+	text, err := candidateText(c)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if fim != nil {
+		text = fim.Write(text) + fim.Flush()
+	}
+	if err := json.NewEncoder(w).Encode(&GenerateResponse{
+		Model:      req.Model,
+		Response:   text,
+		CreatedAt:  end,
+		Done:       true,
+		DoneReason: toDoneReason(c.FinishReason),
+		Context:    h.saveContext(&req, chat.History),
+		Metrics:    responseMetrics(start, requested, end, gresp.UsageMetadata),
+	}); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode generate response: %v", err)
+		return
+	}
+}
+
+// streamingGenerateHandler sends a response line for every chunk
+// Gemini streams back, followed by a done line with the reason
+// generation stopped, token counts, timings and the context to
+// continue the conversation with, which is history followed by the
+// response. If fim isn't nil, the chunks are stripped of what Gemini
+// repeats of the prefix and the suffix.
+func (h *handlers) streamingGenerateHandler(w http.ResponseWriter, r *http.Request, req *GenerateRequest, stats *streamStats, history []*genai.Content, iter contentStream, fim *internal.FillInTheMiddleStripper) {
+	var reply strings.Builder
+	var replied bool
+	lw := newLineWriter(w)
+	for {
+		gresp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			lw.writeError(w, r, err, "failed to stream response")
+			return
+		}
+		c := stats.add(gresp)
+		if c == nil {
+			continue
+		}
+		text, err := candidateText(c)
+		if err != nil {
+			lw.writeError(w, r, err, "failed to stream response")
+			return
+		}
+		// Blocked candidates have no content to continue from.
+		if c.Content != nil {
+			replied = true
+			reply.WriteString(text)
+		}
+		if fim != nil {
+			text = fim.Write(text)
+		}
+		if text == "" {
+			continue
+		}
+		if err := lw.write(&GenerateResponse{
+			Model:     req.Model,
+			CreatedAt: time.Now(),
+			Response:  text,
+		}); err != nil {
+			log.Printf("Error writing response: %v", err)
+			return
+		}
+	}
+
+	if fim != nil {
+		if text := fim.Flush(); text != "" {
+			if err := lw.write(&GenerateResponse{
+				Model:     req.Model,
+				CreatedAt: time.Now(),
+				Response:  text,
+			}); err != nil {
+				log.Printf("Error writing response: %v", err)
+				return
+			}
+		}
+	}
+
+	if replied {
+		history = append(history, &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(reply.String())}})
+	}
+	end := time.Now()
+	if err := lw.write(&GenerateResponse{
+		Model:      req.Model,
+		CreatedAt:  end,
+		Done:       true,
+		DoneReason: toDoneReason(stats.finishReason),
+		Context:    h.saveContext(req, history),
+		Metrics:    stats.metrics(end),
+	}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// candidateText returns the text of a candidate.
+func candidateText(c *genai.Candidate) (string, error) {
+	if c.Content == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	for _, part := range c.Content.Parts {
+		switch v := part.(type) {
+		case genai.Text:
+			b.WriteString(string(v))
+		default:
+			return "", fmt.Errorf("unsupported part type: %T", v)
+		}
+	}
+	return b.String(), nil
+}
+
+// saveContext saves a conversation that got a response and returns
+// its context. Conversations without a response, such as blocked
+// ones, can't be continued, and neither can raw ones.
+func (h *handlers) saveContext(req *GenerateRequest, history []*genai.Content) []int {
+	n := len(history)
+	if req.Raw || n == 0 || history[n-1].Role != "model" {
+		return nil
+	}
+	return h.contexts.save(history)
+}
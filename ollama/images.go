@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const defaultMaxImageSize = 20 << 20
+
+// toGeminiImages decodes base64 encoded images into blobs.
+// Ollama doesn't send MIME types, so they are sniffed.
+func (h *handlers) toGeminiImages(images []string) ([]genai.Part, error) {
+	parts := make([]genai.Part, 0, len(images))
+	for i, image := range images {
+		blob, err := decodeImage(image, h.maxImageSize)
+		if err != nil {
+			return nil, fmt.Errorf("images[%d]: %v", i, err)
+		}
+		parts = append(parts, blob)
+	}
+	return parts, nil
+}
+
+// withImages returns the parts of a text sent along images.
+// Gemini rejects empty text parts, so images may be sent alone.
+func withImages(text string, images []genai.Part) []genai.Part {
+	if text == "" && len(images) > 0 {
+		return images
+	}
+	return append([]genai.Part{genai.Text(text)}, images...)
+}
+
+func decodeImage(data string, maxSize int64) (genai.Blob, error) {
+	// Some clients send data URIs instead of plain base64.
+	if strings.HasPrefix(data, "data:") {
+		_, after, ok := strings.Cut(data, ",")
+		if !ok {
+			return genai.Blob{}, fmt.Errorf("malformed data URI")
+		}
+		data = after
+	}
+	if int64(base64.StdEncoding.DecodedLen(len(data))) > maxSize {
+		return genai.Blob{}, fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return genai.Blob{}, fmt.Errorf("failed to decode image: %v", err)
+	}
+	mimeType := detectImageType(b)
+	if mimeType == "" {
+		return genai.Blob{}, fmt.Errorf("unsupported image type, only PNG, JPEG, WebP, HEIC and HEIF are supported")
+	}
+	return genai.Blob{MIMEType: mimeType, Data: b}, nil
+}
+
+// detectImageType returns the MIME type of an image Gemini
+// supports, or an empty string for anything else.
+func detectImageType(b []byte) string {
+	switch t := http.DetectContentType(b); t {
+	case "image/png", "image/jpeg", "image/webp":
+		return t
+	}
+	// HEIC and HEIF are ISO base media files, identified
+	// by the brand of their ftyp box.
+	if len(b) >= 12 && bytes.Equal(b[4:8], []byte("ftyp")) {
+		switch string(b[8:12]) {
+		case "heic", "heix", "heim", "heis":
+			return "image/heic"
+		case "mif1", "msf1", "heif":
+			return "image/heif"
+		}
+	}
+	return ""
+}
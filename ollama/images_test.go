@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func Test_decodeImage(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")
+	tests := []struct {
+		name         string
+		data         string
+		maxSize      int64
+		wantMIMEType string
+		wantErr      bool
+	}{
+		{
+			name:         "png",
+			data:         base64.StdEncoding.EncodeToString(png),
+			maxSize:      defaultMaxImageSize,
+			wantMIMEType: "image/png",
+		},
+		{
+			name:         "heic",
+			data:         base64.StdEncoding.EncodeToString(heic),
+			maxSize:      defaultMaxImageSize,
+			wantMIMEType: "image/heic",
+		},
+		{
+			name:         "data uri",
+			data:         "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+			maxSize:      defaultMaxImageSize,
+			wantMIMEType: "image/png",
+		},
+		{
+			name:    "corrupt",
+			data:    "not base64!",
+			maxSize: defaultMaxImageSize,
+			wantErr: true,
+		},
+		{
+			name:    "not an image",
+			data:    base64.StdEncoding.EncodeToString([]byte("hello world")),
+			maxSize: defaultMaxImageSize,
+			wantErr: true,
+		},
+		{
+			name:    "too large",
+			data:    base64.StdEncoding.EncodeToString(png),
+			maxSize: 8,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob, err := decodeImage(tt.data, tt.maxSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if blob.MIMEType != tt.wantMIMEType {
+				t.Errorf("decodeImage() MIME type = %q, want %q", blob.MIMEType, tt.wantMIMEType)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Ollama reports how long loading the model, evaluating the prompt and
+// generating took. Gemini runs remotely, so the proxy measures:
+//
+//   - load duration: from receiving the request to calling Gemini,
+//   - prompt evaluation: from calling Gemini to the first chunk,
+//   - evaluation: from the first chunk to the last.
+//
+// Responses that aren't streamed have no first chunk, the whole call
+// is reported as evaluation, so tokens per second stay meaningful.
+
+// streamStats collects what the done line of a stream reports.
+type streamStats struct {
+	start        time.Time
+	requested    time.Time
+	firstChunk   time.Time
+	finishReason genai.FinishReason
+	usage        *genai.UsageMetadata
+}
+
+// newStreamStats returns the stats of a stream requested
+// from Gemini now, for a request received at start.
+func newStreamStats(start time.Time) *streamStats {
+	return &streamStats{start: start, requested: time.Now()}
+}
+
+// add records a streamed response and returns its first
+// candidate, or nil if the response has no candidates.
+func (s *streamStats) add(gresp *genai.GenerateContentResponse) *genai.Candidate {
+	if s.firstChunk.IsZero() {
+		s.firstChunk = time.Now()
+	}
+	if gresp.UsageMetadata != nil {
+		s.usage = gresp.UsageMetadata
+	}
+	if len(gresp.Candidates) == 0 {
+		return nil
+	}
+	c := gresp.Candidates[0]
+	if c.FinishReason != genai.FinishReasonUnspecified {
+		s.finishReason = c.FinishReason
+	}
+	return c
+}
+
+// metrics returns the metrics of a stream that ended at end.
+func (s *streamStats) metrics(end time.Time) Metrics {
+	firstChunk := s.firstChunk
+	if firstChunk.IsZero() {
+		firstChunk = end
+	}
+	m := Metrics{
+		TotalDuration:      end.Sub(s.start),
+		LoadDuration:       s.requested.Sub(s.start),
+		PromptEvalDuration: firstChunk.Sub(s.requested),
+		EvalDuration:       end.Sub(firstChunk),
+	}
+	setTokenCounts(&m, s.usage)
+	return m
+}
+
+// responseMetrics returns the metrics of a response that isn't
+// streamed, for a request received at start, sent to Gemini at
+// requested and answered at end.
+func responseMetrics(start, requested, end time.Time, usage *genai.UsageMetadata) Metrics {
+	m := Metrics{
+		TotalDuration: end.Sub(start),
+		LoadDuration:  requested.Sub(start),
+		EvalDuration:  end.Sub(requested),
+	}
+	setTokenCounts(&m, usage)
+	return m
+}
+
+// setTokenCounts sets the token counts of m. Gemini's total
+// includes the prompt, only the candidates are evaluated.
+func setTokenCounts(m *Metrics, usage *genai.UsageMetadata) {
+	if usage == nil {
+		return
+	}
+	m.PromptEvalCount = usage.PromptTokenCount
+	m.EvalCount = usage.CandidatesTokenCount
+}
+
+// toDoneReason converts the reason Gemini stopped generating into an
+// Ollama done reason. Ollama only knows stop and length, the reasons
+// specific to Gemini are passed on in lower case.
+func toDoneReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonMaxTokens:
+		return "length"
+	case genai.FinishReasonSafety:
+		return "safety"
+	case genai.FinishReasonRecitation:
+		return "recitation"
+	case genai.FinishReasonOther:
+		return "other"
+	default:
+		return "stop"
+	}
+}
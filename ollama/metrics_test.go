@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func Test_responseMetrics(t *testing.T) {
+	start := time.Now()
+	requested := start.Add(2 * time.Millisecond)
+	end := requested.Add(time.Second)
+	usage := &genai.UsageMetadata{PromptTokenCount: 7, CandidatesTokenCount: 40, TotalTokenCount: 47}
+
+	want := Metrics{
+		TotalDuration:   time.Second + 2*time.Millisecond,
+		LoadDuration:    2 * time.Millisecond,
+		PromptEvalCount: 7,
+		EvalCount:       40,
+		EvalDuration:    time.Second,
+	}
+	if got := responseMetrics(start, requested, end, usage); got != want {
+		t.Errorf("responseMetrics() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_streamStats(t *testing.T) {
+	start := time.Now().Add(-time.Millisecond)
+	s := newStreamStats(start)
+	s.add(&genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: &genai.Content{Parts: []genai.Part{genai.Text("Hello")}}}},
+	})
+	s.add(&genai.GenerateContentResponse{
+		Candidates:    []*genai.Candidate{{FinishReason: genai.FinishReasonMaxTokens}},
+		UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 7, CandidatesTokenCount: 3, TotalTokenCount: 10},
+	})
+	end := s.firstChunk.Add(time.Second)
+
+	m := s.metrics(end)
+	if m.PromptEvalCount != 7 || m.EvalCount != 3 {
+		t.Errorf("metrics() counts = %d, %d, want 7, 3", m.PromptEvalCount, m.EvalCount)
+	}
+	if m.EvalDuration != time.Second {
+		t.Errorf("metrics() EvalDuration = %v, want %v", m.EvalDuration, time.Second)
+	}
+	if sum := m.LoadDuration + m.PromptEvalDuration + m.EvalDuration; sum != m.TotalDuration {
+		t.Errorf("metrics() durations add up to %v, want %v", sum, m.TotalDuration)
+	}
+	if got := toDoneReason(s.finishReason); got != "length" {
+		t.Errorf("toDoneReason() = %q, want %q", got, "length")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// modelfile holds the directives of an Ollama Modelfile
+// the proxy supports.
+type modelfile struct {
+	From       string
+	System     string
+	Template   string
+	Parameters map[string]any
+}
+
+// parseModelfile parses a Modelfile. Arguments may be quoted, and
+// span multiple lines if quoted with """. Repeated stop parameters
+// are collected, other repeated directives override earlier ones.
+func parseModelfile(text string) (*modelfile, error) {
+	mf := &modelfile{Parameters: make(map[string]any)}
+	s := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		instruction, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		// Multi-line arguments run until the closing quotes,
+		// PARAMETER values can't span lines.
+		if strings.HasPrefix(arg, `"""`) {
+			arg = arg[3:]
+			for !strings.Contains(arg, `"""`) {
+				if !s.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated \"\"\"", n)
+				}
+				n++
+				arg += "\n" + s.Text()
+			}
+			arg, _, _ = strings.Cut(arg, `"""`)
+		} else {
+			arg = unquote(arg)
+		}
+
+		switch strings.ToUpper(instruction) {
+		case "FROM":
+			mf.From = arg
+		case "SYSTEM":
+			mf.System = arg
+		case "TEMPLATE":
+			mf.Template = arg
+		case "PARAMETER":
+			name, value, ok := strings.Cut(arg, " ")
+			if !ok {
+				return nil, fmt.Errorf("line %d: PARAMETER needs a name and a value", n)
+			}
+			mf.setParameter(name, unquote(strings.TrimSpace(value)))
+		case "LICENSE":
+			// Gemini models are licensed by Google, not the Modelfile.
+		default:
+			return nil, fmt.Errorf("line %d: unsupported instruction %q", n, instruction)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
+
+// setParameter sets a parameter, converting its value
+// to a number or a boolean where it is one.
+func (mf *modelfile) setParameter(name, value string) {
+	if name == "stop" {
+		stop, _ := mf.Parameters[name].([]string)
+		mf.Parameters[name] = append(stop, value)
+		return
+	}
+	var v any
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		v = value
+	}
+	mf.Parameters[name] = v
+}
+
+// unquote removes the double quotes around s, if any.
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// toOptions converts parameters into options. Parameters that
+// aren't Ollama options or have the wrong type are an error.
+func toOptions(params map[string]any) (Options, error) {
+	var o Options
+	if len(params) == 0 {
+		return o, nil
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return o, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&o); err != nil {
+		return o, fmt.Errorf("invalid parameters: %v", err)
+	}
+	return o, nil
+}
+
+// mergeOptions returns the options set in override,
+// completed with the ones only set in base.
+func mergeOptions(base, override Options) Options {
+	merged := override
+	b := reflect.ValueOf(base)
+	m := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < m.NumField(); i++ {
+		if m.Field(i).IsNil() {
+			m.Field(i).Set(b.Field(i))
+		}
+	}
+	return merged
+}
+
+// toModelfile formats an alias as a Modelfile.
+func toModelfile(a *alias) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", a.From)
+	if a.System != "" {
+		fmt.Fprintf(&b, "SYSTEM \"\"\"%s\"\"\"\n", a.System)
+	}
+	if a.Template != "" {
+		fmt.Fprintf(&b, "TEMPLATE \"\"\"%s\"\"\"\n", a.Template)
+	}
+	for _, p := range toParameters(a.Parameters) {
+		fmt.Fprintf(&b, "PARAMETER %s %s\n", p.name, p.value)
+	}
+	return b.String()
+}
+
+// parameter is an option formatted as a Modelfile parameter.
+type parameter struct {
+	name, value string
+}
+
+// toParameters returns a parameter for every option set,
+// and one for every stop sequence.
+func toParameters(o Options) []parameter {
+	var params []parameter
+	v := reflect.ValueOf(o)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.IsNil() {
+			continue
+		}
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if stop, ok := f.Interface().(Strings); ok {
+			for _, s := range stop {
+				params = append(params, parameter{name, `"` + s + `"`})
+			}
+			continue
+		}
+		params = append(params, parameter{name, fmt.Sprint(f.Elem().Interface())})
+	}
+	return params
+}
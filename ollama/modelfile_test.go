@@ -0,0 +1,175 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseModelfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		modelfile string
+		want      *modelfile
+		wantErr   bool
+	}{
+		{
+			name: "all directives",
+			modelfile: `# A summarizer.
+FROM gemini-1.5-flash
+SYSTEM You summarize text in one paragraph.
+PARAMETER temperature 0.2
+PARAMETER num_predict 256
+PARAMETER stop "<end>"
+PARAMETER stop "###"
+TEMPLATE "Summarize: {{ .Prompt }}"
+`,
+			want: &modelfile{
+				From:     "gemini-1.5-flash",
+				System:   "You summarize text in one paragraph.",
+				Template: "Summarize: {{ .Prompt }}",
+				Parameters: map[string]any{
+					"temperature": 0.2,
+					"num_predict": float64(256),
+					"stop":        []string{"<end>", "###"},
+				},
+			},
+		},
+		{
+			name: "multi-line",
+			modelfile: `from gemini-1.5-pro
+system """
+You are a pirate.
+Answer like one."""
+template """{{ .System }}
+{{ .Prompt }}
+"""`,
+			want: &modelfile{
+				From:       "gemini-1.5-pro",
+				System:     "\nYou are a pirate.\nAnswer like one.",
+				Template:   "{{ .System }}\n{{ .Prompt }}\n",
+				Parameters: map[string]any{},
+			},
+		},
+		{
+			name:      "unterminated",
+			modelfile: "FROM gemini-1.5-pro\nSYSTEM \"\"\"You are a pirate.",
+			wantErr:   true,
+		},
+		{
+			name:      "parameter without value",
+			modelfile: "FROM gemini-1.5-pro\nPARAMETER temperature",
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported instruction",
+			modelfile: "FROM gemini-1.5-pro\nADAPTER ./lora.gguf",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseModelfile(tt.modelfile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseModelfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseModelfile() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_toOptions(t *testing.T) {
+	temperature, numPredict := float32(0.2), int32(256)
+	tests := []struct {
+		name    string
+		params  map[string]any
+		want    Options
+		wantErr bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:   "options",
+			params: map[string]any{"temperature": 0.2, "num_predict": float64(256), "stop": []string{"<end>"}},
+			want:   Options{Temperature: &temperature, NumPredict: &numPredict, Stop: Strings{"<end>"}},
+		},
+		{
+			name:    "unknown",
+			params:  map[string]any{"creativity": 1},
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			params:  map[string]any{"temperature": "hot"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toOptions(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mergeOptions(t *testing.T) {
+	low, high, topK := float32(0.2), float32(0.9), int32(40)
+	base := Options{Temperature: &low, TopK: &topK, Stop: Strings{"<end>"}}
+	override := Options{Temperature: &high}
+	want := Options{Temperature: &high, TopK: &topK, Stop: Strings{"<end>"}}
+	if got := mergeOptions(base, override); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeOptions() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_toModelfile(t *testing.T) {
+	temperature := float32(0.2)
+	a := &alias{
+		From:       "gemini-1.5-flash",
+		System:     "Be brief.",
+		Parameters: Options{Temperature: &temperature, Stop: Strings{"<end>", "###"}},
+	}
+	want := "FROM gemini-1.5-flash\n" +
+		"SYSTEM \"\"\"Be brief.\"\"\"\n" +
+		"PARAMETER temperature 0.2\n" +
+		"PARAMETER stop \"<end>\"\n" +
+		"PARAMETER stop \"###\"\n"
+	got := toModelfile(a)
+	if got != want {
+		t.Fatalf("toModelfile() = %q, want %q", got, want)
+	}
+
+	// The Modelfile creates the same alias again.
+	mf, err := parseModelfile(got)
+	if err != nil {
+		t.Fatalf("parseModelfile() error = %v", err)
+	}
+	options, err := toOptions(mf.Parameters)
+	if err != nil {
+		t.Fatalf("toOptions() error = %v", err)
+	}
+	if mf.From != a.From || mf.System != a.System || !reflect.DeepEqual(options, a.Parameters) {
+		t.Errorf("parseModelfile() = %+v, %+v, want %+v", mf, options, a)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// version is the Ollama version whose API the proxy implements.
+// Clients check it to decide which features they can use.
+const version = "0.5.0"
+
+func (h *handlers) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	infos, err := h.models.List(r.Context(), h.client)
+	if err != nil {
+		internal.ErrorHandler(w, r, internal.StatusCode(err), "failed to list models: %v", err)
+		return
+	}
+	aliases := h.aliases.all()
+	resp := &ListResponse{Models: make([]ListModelResponse, 0, len(infos)+len(aliases))}
+	for _, info := range infos {
+		resp.Models = append(resp.Models, toListModelResponse(info))
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		resp.Models = append(resp.Models, toAliasListModelResponse(name, aliases[name]))
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode tags response: %v", err)
+		return
+	}
+}
+
+func (h *handlers) showHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req ShowRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+	if name == "" {
+		internal.ErrorHandler(w, r, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	a := h.resolveModel(name)
+	info, err := h.models.Get(r.Context(), h.client, a.From)
+	if err != nil {
+		internal.ErrorHandler(w, r, internal.StatusCode(err), "failed to get model %q: %v", name, err)
+		return
+	}
+	resp := toShowResponse(info)
+	if _, ok := h.aliases.get(modelName(name)); ok {
+		resp = toAliasShowResponse(resp, a)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode show response: %v", err)
+		return
+	}
+}
+
+func (h *handlers) psHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Gemini models are served remotely, the proxy never loads any.
+	if err := json.NewEncoder(w).Encode(&ListResponse{Models: []ListModelResponse{}}); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode ps response: %v", err)
+		return
+	}
+}
+
+func (h *handlers) versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&VersionResponse{Version: version}); err != nil {
+		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode version response: %v", err)
+		return
+	}
+}
+
+// modelName returns the Gemini model name of an Ollama model name.
+// Ollama clients add the default ":latest" tag to untagged names,
+// which Gemini doesn't know about.
+func modelName(name string) string {
+	return strings.TrimSuffix(name, ":latest")
+}
+
+func toListModelResponse(info *genai.ModelInfo) ListModelResponse {
+	name := strings.TrimPrefix(info.Name, "models/")
+	return ListModelResponse{
+		Name:    name,
+		Model:   name,
+		Digest:  modelDigest(info),
+		Details: toModelDetails(info),
+	}
+}
+
+func toShowResponse(info *genai.ModelInfo) *ShowResponse {
+	name := strings.TrimPrefix(info.Name, "models/")
+	details := toModelDetails(info)
+
+	var params strings.Builder
+	if info.Temperature > 0 {
+		fmt.Fprintf(&params, "%-30s %v\n", "temperature", info.Temperature)
+	}
+	if info.TopP > 0 {
+		fmt.Fprintf(&params, "%-30s %v\n", "top_p", info.TopP)
+	}
+	if info.TopK > 0 {
+		fmt.Fprintf(&params, "%-30s %v\n", "top_k", info.TopK)
+	}
+
+	var capabilities []string
+	if slices.Contains(info.SupportedGenerationMethods, "generateContent") {
+		capabilities = append(capabilities, "completion", "tools")
+	}
+	if slices.Contains(info.SupportedGenerationMethods, "embedContent") {
+		capabilities = append(capabilities, "embedding")
+	}
+
+	return &ShowResponse{
+		Modelfile:  fmt.Sprintf("FROM %s\n", name),
+		Parameters: params.String(),
+		Template:   "{{ .Prompt }}",
+		Details:    details,
+		ModelInfo: map[string]any{
+			"general.architecture":                 details.Family,
+			"general.basename":                     info.BaseModelID,
+			"general.description":                  info.Description,
+			"general.name":                         info.DisplayName,
+			"general.version":                      info.Version,
+			details.Family + ".context_length":     info.InputTokenLimit,
+			details.Family + ".output_token_limit": info.OutputTokenLimit,
+		},
+		Capabilities: capabilities,
+	}
+}
+
+// toAliasListModelResponse lists an alias. Its digest changes
+// whenever its Modelfile does.
+func toAliasListModelResponse(name string, a *alias) ListModelResponse {
+	sum := sha256.Sum256([]byte(toModelfile(a)))
+	details := toModelDetails(&genai.ModelInfo{Name: a.From})
+	details.ParentModel = a.From
+	return ListModelResponse{
+		Name:       name,
+		Model:      name,
+		ModifiedAt: a.ModifiedAt,
+		Digest:     hex.EncodeToString(sum[:]),
+		Details:    details,
+	}
+}
+
+// toAliasShowResponse completes the show response of
+// the Gemini model of an alias with its settings.
+func toAliasShowResponse(resp *ShowResponse, a *alias) *ShowResponse {
+	resp.Modelfile = toModelfile(a)
+	resp.System = a.System
+	if a.Template != "" {
+		resp.Template = a.Template
+	}
+	if params := toParameters(a.Parameters); len(params) > 0 {
+		var b strings.Builder
+		for _, p := range params {
+			fmt.Fprintf(&b, "%-30s %s\n", p.name, p.value)
+		}
+		resp.Parameters = b.String()
+	}
+	resp.Details.ParentModel = a.From
+	resp.ModifiedAt = a.ModifiedAt
+	return resp
+}
+
+// toModelDetails describes a Gemini model the way Ollama describes its
+// models. Gemini doesn't publish parameter counts or quantization, so
+// these are reported as unknown.
+func toModelDetails(info *genai.ModelInfo) ModelDetails {
+	family := modelFamily(info)
+	return ModelDetails{
+		Format:            "gemini",
+		Family:            family,
+		Families:          []string{family},
+		ParameterSize:     "unknown",
+		QuantizationLevel: "unknown",
+	}
+}
+
+// modelFamily returns the family of a model, such as gemini or gemma.
+func modelFamily(info *genai.ModelInfo) string {
+	name := strings.TrimPrefix(info.Name, "models/")
+	if strings.Contains(name, "embedding") {
+		return "embedding"
+	}
+	family, _, _ := strings.Cut(name, "-")
+	return family
+}
+
+// modelDigest returns a stable digest of a model. Clients use
+// digests to tell models apart, a Gemini model is identified
+// by its name and version.
+func modelDigest(info *genai.ModelInfo) string {
+	sum := sha256.Sum256([]byte(info.Name + "@" + info.Version))
+	return hex.EncodeToString(sum[:])
+}
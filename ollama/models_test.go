@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func Test_toShowResponse(t *testing.T) {
+	info := &genai.ModelInfo{
+		Name:                       "models/gemini-1.5-flash",
+		BaseModelID:                "gemini-1.5-flash",
+		Version:                    "001",
+		DisplayName:                "Gemini 1.5 Flash",
+		Description:                "Fast and versatile multimodal model.",
+		InputTokenLimit:            1000000,
+		OutputTokenLimit:           8192,
+		SupportedGenerationMethods: []string{"generateContent", "countTokens"},
+		Temperature:                1,
+		TopP:                       0.95,
+		TopK:                       64,
+	}
+	want := &ShowResponse{
+		Modelfile:  "FROM gemini-1.5-flash\n",
+		Parameters: "temperature                    1\ntop_p                          0.95\ntop_k                          64\n",
+		Template:   "{{ .Prompt }}",
+		Details: ModelDetails{
+			Format:            "gemini",
+			Family:            "gemini",
+			Families:          []string{"gemini"},
+			ParameterSize:     "unknown",
+			QuantizationLevel: "unknown",
+		},
+		ModelInfo: map[string]any{
+			"general.architecture":      "gemini",
+			"general.basename":          "gemini-1.5-flash",
+			"general.description":       "Fast and versatile multimodal model.",
+			"general.name":              "Gemini 1.5 Flash",
+			"general.version":           "001",
+			"gemini.context_length":     int32(1000000),
+			"gemini.output_token_limit": int32(8192),
+		},
+		Capabilities: []string{"completion", "tools"},
+	}
+	if got := toShowResponse(info); !reflect.DeepEqual(got, want) {
+		t.Errorf("toShowResponse() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_modelFamily(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "models/gemini-1.5-pro", want: "gemini"},
+		{name: "models/gemma-2-9b-it", want: "gemma"},
+		{name: "models/text-embedding-004", want: "embedding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modelFamily(&genai.ModelInfo{Name: tt.name}); got != tt.want {
+				t.Errorf("modelFamily() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,9 +18,8 @@ package ollama
 
 import (
 	"encoding/json"
-	"io"
-	"net/http"
-	"strings"
+	"fmt"
+	"log"
 	"time"
 
 	"github.com/google-gemini/proxy-to-gemini/internal"
@@ -29,117 +28,71 @@ import (
 )
 
 type handlers struct {
-	client *genai.Client
-}
+	client    *genai.Client
+	taskTypes map[string]genai.TaskType
+	models    internal.ModelCache
+	contexts  *contextStore
+	aliases   *aliasStore
 
-func RegisterHandlers(r *mux.Router, client *genai.Client) {
-	handlers := &handlers{client: client}
-	r.HandleFunc("/api/generate", handlers.generateHandler)
-	r.HandleFunc("/api/embed", handlers.embedHandler)
+	maxImageSize int64
 }
 
-func (h *handlers) generateHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
-		return
-	}
-	defer r.Body.Close()
+// Config configures the ollama handlers.
+// The zero value is ready to use.
+type Config struct {
+	// EmbeddingTaskTypes maps embedding model names to the task
+	// type used when a request doesn't set task_type.
+	EmbeddingTaskTypes map[string]genai.TaskType
 
-	var req GenerateRequest
-	if err := json.Unmarshal(body, &req); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to unmarshal request body: %v", err)
-		return
-	}
+	// MaxImageSize is the maximum size of an image in bytes.
+	// If zero, images up to 20MB are accepted.
+	MaxImageSize int64
 
-	model := h.client.GenerativeModel(req.Model)
-	model.GenerationConfig = genai.GenerationConfig{
-		Temperature:     req.Options.Temperature,
-		MaxOutputTokens: req.Options.NumPredict,
-		TopK:            req.Options.TopK,
-		TopP:            req.Options.TopP,
-	}
-	if req.Options.Stop != nil {
-		model.GenerationConfig.StopSequences = []string{*req.Options.Stop}
-	}
-	if req.System != "" {
-		model.SystemInstruction = &genai.Content{
-			Role:  "system",
-			Parts: []genai.Part{genai.Text(req.System)},
-		}
-	}
-	parts := []genai.Part{genai.Text(req.Prompt)}
-	gresp, err := model.GenerateContent(r.Context(), parts...)
-	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to generate content: %v", err)
-		return
-	}
-	if len(gresp.Candidates) == 0 {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "no candidates returned")
-		return
-	}
-
-	responseBuilder := &strings.Builder{}
-	for _, part := range gresp.Candidates[0].Content.Parts {
-		switch v := part.(type) {
-		case genai.Text:
-			responseBuilder.WriteString(string(v))
-		default:
-			internal.ErrorHandler(w, r, http.StatusInternalServerError, "unsupported part type: %T", v)
-			return
-		}
-	}
-	if err := json.NewEncoder(w).Encode(&GenerateResponse{
-		Model:           req.Model,
-		Response:        responseBuilder.String(),
-		CreatedAt:       time.Now(),
-		PromptEvalCount: gresp.UsageMetadata.PromptTokenCount,
-		EvalCount:       gresp.UsageMetadata.TotalTokenCount,
-		Done:            true,
-	}); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode generate response: %v", err)
-		return
-	}
-}
+	// ContextTTL is how long the conversation of a /api/generate
+	// context is kept after its last use. If zero, 30 minutes.
+	ContextTTL time.Duration
 
-func (h *handlers) embedHandler(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
-		return
-	}
-	defer r.Body.Close()
+	// MaxContexts is the maximum number of conversations kept
+	// for /api/generate contexts. If zero, 1000.
+	MaxContexts int
 
-	var req EmbedRequest
-	if err := json.Unmarshal(body, &req); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to unmarshal request body: %v", err)
-		return
-	}
+	// MaxContextBytes is the maximum size of the conversations kept
+	// for /api/generate contexts, counting their texts and images.
+	// If zero, 256MB.
+	MaxContextBytes int64
 
-	model := h.client.EmbeddingModel(req.Model)
-	batch := model.NewBatch()
-	for _, input := range req.Input {
-		batch.AddContent(genai.Text(input))
-	}
+	// AliasesFile is the file model aliases created with /api/create
+	// and /api/copy are saved to. If empty, they are kept in memory.
+	AliasesFile string
+}
 
-	gresp, err := model.BatchEmbedContents(r.Context(), batch)
+func RegisterHandlers(r *mux.Router, client *genai.Client, config Config) {
+	aliases, err := newAliasStore(config.AliasesFile)
 	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to create embedding: %v", err)
-		return
+		// Saving would overwrite the aliases that failed to load.
+		log.Printf("Failed to load model aliases, keeping new ones in memory only: %v", err)
+		aliases, _ = newAliasStore("")
 	}
-
-	embeddings := make([][]float32, 0, len(gresp.Embeddings))
-	for _, embedding := range gresp.Embeddings {
-		embeddings = append(embeddings, embedding.Values)
+	handlers := &handlers{
+		client:       client,
+		taskTypes:    config.EmbeddingTaskTypes,
+		contexts:     newContextStore(config.ContextTTL, config.MaxContexts, config.MaxContextBytes),
+		aliases:      aliases,
+		maxImageSize: config.MaxImageSize,
 	}
-
-	if err := json.NewEncoder(w).Encode(&EmbedResponse{
-		Model:      req.Model,
-		Embeddings: embeddings,
-	}); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode embeddings response: %v", err)
-		return
+	if handlers.maxImageSize <= 0 {
+		handlers.maxImageSize = defaultMaxImageSize
 	}
+	r.HandleFunc("/api/generate", handlers.generateHandler)
+	r.HandleFunc("/api/chat", handlers.chatHandler)
+	r.HandleFunc("/api/embed", handlers.embedHandler)
+	r.HandleFunc("/api/tags", handlers.tagsHandler)
+	r.HandleFunc("/api/show", handlers.showHandler)
+	r.HandleFunc("/api/ps", handlers.psHandler)
+	r.HandleFunc("/api/version", handlers.versionHandler)
+	r.HandleFunc("/api/create", handlers.createHandler)
+	r.HandleFunc("/api/copy", handlers.copyHandler)
+	r.HandleFunc("/api/delete", handlers.deleteHandler)
 }
 
 type GenerateRequest struct {
@@ -148,39 +101,260 @@ type GenerateRequest struct {
 	Suffix  string  `json:"suffix,omitempty"`
 	Options Options `json:"options,omitempty"`
 	System  string  `json:"system,omitempty"`
+	Stream  *bool   `json:"stream,omitempty"`
+
+	// Format is either "json" or a JSON schema
+	// the response has to conform to.
+	Format json.RawMessage `json:"format,omitempty"`
 
-	// TODO: Support images.
-	// TODO: Support format.
-	// TODO: Support streaming.
+	// Images are base64 encoded images sent along the prompt.
+	Images []string `json:"images,omitempty"`
+
+	// Context is the context of a previous response,
+	// whose conversation the prompt continues.
+	Context []int `json:"context,omitempty"`
+
+	// Raw sends the prompt as is, without a system prompt.
+	Raw bool `json:"raw,omitempty"`
+
+	// Template is a Go template the prompt is rendered with.
+	Template string `json:"template,omitempty"`
 }
 
 type GenerateResponse struct {
 	Model     string    `json:"model,omitempty"`
-	Response  string    `json:"response,omitempty"`
+	Response  string    `json:"response"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+
+	// Context identifies the conversation so far, send
+	// it with the next prompt to continue it.
+	Context []int `json:"context,omitempty"`
+
+	Metrics
+}
+
+// ChatRequest is the request of /api/chat.
+type ChatRequest struct {
+	Model    string    `json:"model,omitempty"`
+	Messages []Message `json:"messages,omitempty"`
+	Options  Options   `json:"options,omitempty"`
+	Stream   *bool     `json:"stream,omitempty"`
+	Tools    []Tool    `json:"tools,omitempty"`
+
+	// Format is either "json" or a JSON schema
+	// the response has to conform to.
+	Format json.RawMessage `json:"format,omitempty"`
+}
+
+// ChatResponse is a response, or a chunk of a
+// streamed response, of /api/chat.
+type ChatResponse struct {
+	Model     string    `json:"model,omitempty"`
 	CreatedAt time.Time `json:"created_at,omitempty"`
+	Message   Message   `json:"message"`
 
-	PromptEvalCount int32 `json:"prompt_eval_count,omitempty"`
-	EvalCount       int32 `json:"eval_count,omitempty"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
 
-	Done bool `json:"done,omitempty"`
+	Metrics
 }
 
+// Message is a chat message. Role is one of
+// system, user, assistant or tool.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+
+	// Images are base64 encoded images sent along the content.
+	Images []string `json:"images,omitempty"`
+
+	// ToolCalls are the tools an assistant message calls.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+
+	// ToolName is the name of the tool a tool message responds to.
+	ToolName string `json:"tool_name,omitempty"`
+}
+
+// Tool is a tool the model may call.
+// Only function tools are supported.
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Index     int            `json:"index,omitempty"`
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// Metrics are the token counts and timings reported
+// once a response is done. Durations are in nanoseconds.
+type Metrics struct {
+	TotalDuration      time.Duration `json:"total_duration,omitempty"`
+	LoadDuration       time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount    int32         `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int32         `json:"eval_count,omitempty"`
+	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+}
+
+// Options are the model options of Ollama. Only the sampling options
+// Gemini supports are used, the others are accepted and reported in
+// the X-Proxy-Ignored-Options header.
 type Options struct {
-	Temperature *float32 `json:"temperature,omitempty"`
-	Stop        *string  `json:"stop,omitempty"`
-	NumPredict  *int32   `json:"num_predict,omitempty"`
-	TopK        *int32   `json:"top_k,omitempty"`
-	TopP        *float32 `json:"top_p,omitempty"`
+	NumKeep          *int     `json:"num_keep,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	NumPredict       *int32   `json:"num_predict,omitempty"`
+	TopK             *int32   `json:"top_k,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	MinP             *float32 `json:"min_p,omitempty"`
+	TypicalP         *float32 `json:"typical_p,omitempty"`
+	RepeatLastN      *int     `json:"repeat_last_n,omitempty"`
+	Temperature      *float32 `json:"temperature,omitempty"`
+	RepeatPenalty    *float32 `json:"repeat_penalty,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Mirostat         *int     `json:"mirostat,omitempty"`
+	MirostatTau      *float32 `json:"mirostat_tau,omitempty"`
+	MirostatEta      *float32 `json:"mirostat_eta,omitempty"`
+	PenalizeNewline  *bool    `json:"penalize_newline,omitempty"`
+	Stop             Strings  `json:"stop,omitempty"`
+
+	// Runner options, which only apply to models running locally.
+	Numa      *bool `json:"numa,omitempty"`
+	NumCtx    *int  `json:"num_ctx,omitempty"`
+	NumBatch  *int  `json:"num_batch,omitempty"`
+	NumGPU    *int  `json:"num_gpu,omitempty"`
+	MainGPU   *int  `json:"main_gpu,omitempty"`
+	LowVRAM   *bool `json:"low_vram,omitempty"`
+	VocabOnly *bool `json:"vocab_only,omitempty"`
+	UseMMap   *bool `json:"use_mmap,omitempty"`
+	UseMLock  *bool `json:"use_mlock,omitempty"`
+	NumThread *int  `json:"num_thread,omitempty"`
+}
 
-	// TODO: Anything else to support?
+// Strings is a list of strings that can also be sent as a
+// single string, like stop used to be by older clients.
+type Strings []string
+
+func (s *Strings) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = Strings{str}
+		return nil
+	}
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return fmt.Errorf("must be a string or an array of strings")
+	}
+	*s = strs
+	return nil
 }
 
 type EmbedRequest struct {
 	Model string   `json:"model,omitempty"`
 	Input []string `json:"input,omitempty"`
+
+	// TaskType and Title are Gemini extensions. TaskType is a Gemini
+	// task type such as RETRIEVAL_QUERY, and Title is the title of
+	// the documents, which implies RETRIEVAL_DOCUMENT.
+	TaskType string `json:"task_type,omitempty"`
+	Title    string `json:"title,omitempty"`
 }
 
 type EmbedResponse struct {
 	Model      string      `json:"model,omitempty"`
 	Embeddings [][]float32 `json:"embeddings,omitempty"`
 }
+
+// ListResponse is the response of /api/tags and /api/ps.
+type ListResponse struct {
+	Models []ListModelResponse `json:"models"`
+}
+
+type ListModelResponse struct {
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt time.Time    `json:"modified_at"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Details    ModelDetails `json:"details"`
+}
+
+type ModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// ShowRequest is the request of /api/show.
+// Name is the deprecated spelling of Model.
+type ShowRequest struct {
+	Model string `json:"model,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+type ShowResponse struct {
+	Modelfile    string         `json:"modelfile,omitempty"`
+	Parameters   string         `json:"parameters,omitempty"`
+	Template     string         `json:"template,omitempty"`
+	System       string         `json:"system,omitempty"`
+	Details      ModelDetails   `json:"details"`
+	ModelInfo    map[string]any `json:"model_info,omitempty"`
+	Capabilities []string       `json:"capabilities,omitempty"`
+	ModifiedAt   time.Time      `json:"modified_at"`
+}
+
+// CreateRequest is the request of /api/create, which creates a model
+// alias. Name is the deprecated spelling of Model. From, System,
+// Template and Parameters override the directives of the Modelfile.
+type CreateRequest struct {
+	Model     string `json:"model,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Modelfile string `json:"modelfile,omitempty"`
+	Stream    *bool  `json:"stream,omitempty"`
+
+	From       string         `json:"from,omitempty"`
+	System     string         `json:"system,omitempty"`
+	Template   string         `json:"template,omitempty"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+}
+
+// ProgressResponse reports the status of /api/create.
+type ProgressResponse struct {
+	Status string `json:"status"`
+}
+
+// CopyRequest is the request of /api/copy.
+type CopyRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// DeleteRequest is the request of /api/delete.
+// Name is the deprecated spelling of Model.
+type DeleteRequest struct {
+	Model string `json:"model,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+type VersionResponse struct {
+	Version string `json:"version"`
+}
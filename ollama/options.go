@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// supportedOptions are the options toGenerationConfig maps.
+var supportedOptions = map[string]bool{
+	"num_predict": true,
+	"top_k":       true,
+	"top_p":       true,
+	"temperature": true,
+	"stop":        true,
+}
+
+// toGenerationConfig converts the model options
+// into a Gemini generation config.
+func toGenerationConfig(o Options) genai.GenerationConfig {
+	config := genai.GenerationConfig{
+		Temperature:   o.Temperature,
+		TopK:          o.TopK,
+		TopP:          o.TopP,
+		StopSequences: o.Stop,
+	}
+	// Ollama uses negative values to generate until the
+	// model stops, which is what Gemini does by default.
+	if o.NumPredict != nil && *o.NumPredict >= 0 {
+		config.MaxOutputTokens = o.NumPredict
+	}
+	return config
+}
+
+// ignoredOptions returns the names of the options
+// that are set but not supported by Gemini.
+func ignoredOptions(o Options) []string {
+	var ignored []string
+	v := reflect.ValueOf(o)
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if !v.Field(i).IsNil() && !supportedOptions[name] {
+			ignored = append(ignored, name)
+		}
+	}
+	return ignored
+}
+
+// reportIgnoredOptions logs the options Gemini doesn't support and
+// lists them in the X-Proxy-Ignored-Options header, so clients can
+// tell that they had no effect. It has to be called before the
+// response is written.
+func reportIgnoredOptions(w http.ResponseWriter, o Options) {
+	ignored := ignoredOptions(o)
+	if len(ignored) == 0 {
+		return
+	}
+	log.Printf("Ignoring options not supported by Gemini: %v", strings.Join(ignored, ", "))
+	w.Header().Set("X-Proxy-Ignored-Options", strings.Join(ignored, ","))
+}
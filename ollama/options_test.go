@@ -0,0 +1,83 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func Test_toGenerationConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     string
+		want        genai.GenerationConfig
+		wantIgnored []string
+	}{
+		{
+			name:    "supported",
+			options: `{"temperature": 0.5, "top_k": 40, "top_p": 0.9, "num_predict": 128, "stop": ["\n", "user:"]}`,
+			want: genai.GenerationConfig{
+				Temperature:     genai.Ptr[float32](0.5),
+				TopK:            genai.Ptr[int32](40),
+				TopP:            genai.Ptr[float32](0.9),
+				MaxOutputTokens: genai.Ptr[int32](128),
+				StopSequences:   []string{"\n", "user:"},
+			},
+		},
+		{
+			name:    "single stop",
+			options: `{"stop": "user:"}`,
+			want:    genai.GenerationConfig{StopSequences: []string{"user:"}},
+		},
+		{
+			name:    "unlimited",
+			options: `{"num_predict": -1}`,
+			want:    genai.GenerationConfig{},
+		},
+		{
+			name:        "ignored",
+			options:     `{"seed": 42, "num_ctx": 8192, "repeat_penalty": 1.1, "mirostat": 0, "temperature": 0}`,
+			want:        genai.GenerationConfig{Temperature: genai.Ptr[float32](0)},
+			wantIgnored: []string{"seed", "repeat_penalty", "mirostat", "num_ctx"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o Options
+			if err := json.Unmarshal([]byte(tt.options), &o); err != nil {
+				t.Fatal(err)
+			}
+			if got := toGenerationConfig(o); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toGenerationConfig() = %+v, want %+v", got, tt.want)
+			}
+			if got := ignoredOptions(o); !reflect.DeepEqual(got, tt.wantIgnored) {
+				t.Errorf("ignoredOptions() = %v, want %v", got, tt.wantIgnored)
+			}
+		})
+	}
+}
+
+func Test_reportIgnoredOptions(t *testing.T) {
+	rec := httptest.NewRecorder()
+	reportIgnoredOptions(rec, Options{Seed: genai.Ptr(42), NumCtx: genai.Ptr(8192)})
+	if got, want := rec.Header().Get("X-Proxy-Ignored-Options"), "seed,num_ctx"; got != want {
+		t.Errorf("X-Proxy-Ignored-Options = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// contentStream is the part of a genai.GenerateContentResponseIterator
+// that the streaming handlers use.
+type contentStream interface {
+	Next() (*genai.GenerateContentResponse, error)
+}
+
+// lineWriter writes newline delimited JSON and flushes after
+// every line, so clients receive each chunk as soon as it is
+// available.
+type lineWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func newLineWriter(w http.ResponseWriter) *lineWriter {
+	flusher, _ := w.(http.Flusher)
+	return &lineWriter{w: w, flusher: flusher}
+}
+
+func (l *lineWriter) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !l.started {
+		l.started = true
+		l.w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	if _, err := fmt.Fprintf(l.w, "%s\n", b); err != nil {
+		return err
+	}
+	if l.flusher != nil {
+		l.flusher.Flush()
+	}
+	return nil
+}
+
+// writeError reports a Gemini error. Once the first line is sent,
+// the status can't change anymore, so the error is sent as a line
+// instead, the same as Ollama does.
+func (l *lineWriter) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if !l.started {
+		internal.ErrorHandler(w, r, internal.StatusCode(err), "%s: %v", msg, err)
+		return
+	}
+	log.Printf("Error responding: %s: %v", msg, err)
+	if err := l.write(map[string]string{"error": fmt.Sprintf("%s: %v", msg, err)}); err != nil {
+		log.Printf("Error writing error: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// fakeStream streams responses, then ends with err or iterator.Done.
+type fakeStream struct {
+	responses []*genai.GenerateContentResponse
+	err       error
+}
+
+func (s *fakeStream) Next() (*genai.GenerateContentResponse, error) {
+	if len(s.responses) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, iterator.Done
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, nil
+}
+
+// textResponses returns streamed responses with a text each,
+// the last of which finishes with reason and has the usage.
+func textResponses(reason genai.FinishReason, texts ...string) []*genai.GenerateContentResponse {
+	var responses []*genai.GenerateContentResponse
+	for _, text := range texts {
+		responses = append(responses, &genai.GenerateContentResponse{
+			Candidates: []*genai.Candidate{{
+				Content: &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(text)}},
+			}},
+		})
+	}
+	last := responses[len(responses)-1]
+	last.Candidates[0].FinishReason = reason
+	last.UsageMetadata = &genai.UsageMetadata{PromptTokenCount: 3, CandidatesTokenCount: 2, TotalTokenCount: 5}
+	return responses
+}
+
+// readLines decodes the lines of a streamed response.
+func readLines[T any](t *testing.T, body string) []T {
+	t.Helper()
+	var lines []T
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		var v T
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		lines = append(lines, v)
+	}
+	return lines
+}
+
+func Test_streamingGenerateHandler(t *testing.T) {
+	prompt := []*genai.Content{genai.NewUserContent(genai.Text("Hi"))}
+	blocked := []*genai.GenerateContentResponse{{
+		Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}},
+	}}
+	tests := []struct {
+		name        string
+		stream      *fakeStream
+		want        []string
+		wantReason  string
+		wantHistory []*genai.Content // nil if no context is saved
+	}{
+		{
+			name:       "stream",
+			stream:     &fakeStream{responses: textResponses(genai.FinishReasonStop, "Hel", "lo")},
+			want:       []string{"Hel", "lo"},
+			wantReason: "stop",
+			wantHistory: []*genai.Content{
+				prompt[0],
+				{Role: "model", Parts: []genai.Part{genai.Text("Hello")}},
+			},
+		},
+		{
+			name:       "blocked",
+			stream:     &fakeStream{responses: blocked},
+			wantReason: "safety",
+		},
+		{
+			name:   "error after start",
+			stream: &fakeStream{responses: textResponses(genai.FinishReasonUnspecified, "Hel"), err: context.DeadlineExceeded},
+			want:   []string{"Hel"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handlers{contexts: newContextStore(0, 0, 0)}
+			req := &GenerateRequest{Model: "gemini-1.5-flash"}
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+			h.streamingGenerateHandler(rec, r, req, newStreamStats(time.Now()), prompt, tt.stream, nil)
+
+			lines := readLines[struct {
+				GenerateResponse
+				Error string `json:"error"`
+			}](t, rec.Body.String())
+			var got []string
+			for _, line := range lines[:len(lines)-1] {
+				if line.Done || line.Error != "" {
+					t.Fatalf("line %+v before the last line", line)
+				}
+				got = append(got, line.Response)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("responses = %q, want %q", got, tt.want)
+			}
+
+			last := lines[len(lines)-1]
+			if tt.wantReason == "" {
+				if last.Done || !strings.Contains(last.Error, "context deadline exceeded") {
+					t.Errorf("last line = %+v, want an error", last)
+				}
+				return
+			}
+			if !last.Done || last.DoneReason != tt.wantReason || last.Response != "" {
+				t.Errorf("last line = %+v, want done with reason %q", last, tt.wantReason)
+			}
+			if tt.wantHistory == nil {
+				if last.Context != nil {
+					t.Errorf("context = %v, want none", last.Context)
+				}
+				return
+			}
+			if last.PromptEvalCount != 3 || last.EvalCount != 2 {
+				t.Errorf("counts = %d, %d, want 3, 2", last.PromptEvalCount, last.EvalCount)
+			}
+			history, ok := h.contexts.load(last.Context)
+			if !ok || !reflect.DeepEqual(history, tt.wantHistory) {
+				t.Errorf("saved history = %v, %v, want %v", history, ok, tt.wantHistory)
+			}
+		})
+	}
+}
+
+func Test_streamingChatHandler(t *testing.T) {
+	call := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content: &genai.Content{Role: "model", Parts: []genai.Part{genai.FunctionCall{
+				Name: "weather",
+				Args: map[string]any{"city": "Paris"},
+			}}},
+			FinishReason: genai.FinishReasonStop,
+		}},
+	}
+	tests := []struct {
+		name       string
+		stream     *fakeStream
+		want       []Message
+		wantReason string
+	}{
+		{
+			name:   "stream",
+			stream: &fakeStream{responses: textResponses(genai.FinishReasonStop, "Hel", "lo")},
+			want: []Message{
+				{Role: "assistant", Content: "Hel"},
+				{Role: "assistant", Content: "lo"},
+			},
+			wantReason: "stop",
+		},
+		{
+			name:   "tool call",
+			stream: &fakeStream{responses: []*genai.GenerateContentResponse{call}},
+			want: []Message{{Role: "assistant", ToolCalls: []ToolCall{{
+				Function: ToolCallFunction{Name: "weather", Arguments: map[string]any{"city": "Paris"}},
+			}}}},
+			wantReason: "stop",
+		},
+		{
+			name:   "error after start",
+			stream: &fakeStream{responses: textResponses(genai.FinishReasonUnspecified, "Hel"), err: context.DeadlineExceeded},
+			want:   []Message{{Role: "assistant", Content: "Hel"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+			streamingChatHandler(rec, r, "gemini-1.5-flash", newStreamStats(time.Now()), tt.stream)
+
+			lines := readLines[struct {
+				ChatResponse
+				Error string `json:"error"`
+			}](t, rec.Body.String())
+			var got []Message
+			for _, line := range lines[:len(lines)-1] {
+				if line.Done || line.Error != "" {
+					t.Fatalf("line %+v before the last line", line)
+				}
+				got = append(got, line.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %+v, want %+v", got, tt.want)
+			}
+
+			last := lines[len(lines)-1]
+			if tt.wantReason == "" {
+				if last.Done || !strings.Contains(last.Error, "context deadline exceeded") {
+					t.Errorf("last line = %+v, want an error", last)
+				}
+				return
+			}
+			if !last.Done || last.DoneReason != tt.wantReason || !reflect.DeepEqual(last.Message, Message{Role: "assistant"}) {
+				t.Errorf("last line = %+v, want done with reason %q", last, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_lineWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		err      error
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{
+			name:     "lines",
+			lines:    []string{"a", "b"},
+			wantCode: http.StatusOK,
+			wantType: "application/x-ndjson",
+			wantBody: "{\"response\":\"a\"}\n{\"response\":\"b\"}\n",
+		},
+		{
+			name:     "error before first line",
+			err:      &googleapi.Error{Code: http.StatusTooManyRequests, Message: "slow down"},
+			wantCode: http.StatusTooManyRequests,
+			wantType: "text/plain; charset=utf-8",
+			wantBody: "failed: googleapi: Error 429: slow down\n",
+		},
+		{
+			name:     "error after first line",
+			lines:    []string{"a"},
+			err:      errors.New("boom"),
+			wantCode: http.StatusOK,
+			wantType: "application/x-ndjson",
+			wantBody: "{\"response\":\"a\"}\n{\"error\":\"failed: boom\"}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+			lw := newLineWriter(rec)
+			for _, line := range tt.lines {
+				if err := lw.write(map[string]string{"response": line}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.err != nil {
+				lw.writeError(rec, req, tt.err, "failed")
+			}
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateValues are the variables of an Ollama prompt template.
+// Response is always empty. Like Ollama, everything after it in the
+// template is dropped, such as end of turn tokens, so the rendered
+// prompt ends where the model starts to respond.
+type templateValues struct {
+	System   string
+	Prompt   string
+	Response string
+	Suffix   string
+}
+
+// renderTemplate renders an Ollama prompt template.
+func renderTemplate(text string, v templateValues) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	truncateAtResponse(t.Root)
+	var b strings.Builder
+	if err := t.Execute(&b, v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// truncateAtResponse removes the nodes after the first use of
+// .Response from a template tree and reports whether there was one.
+// The bodies of actions that test .Response are removed too.
+func truncateAtResponse(n parse.Node) bool {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for i, c := range n.Nodes {
+			if truncateAtResponse(c) {
+				n.Nodes = n.Nodes[:i+1]
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesResponse(n.Pipe)
+	case *parse.IfNode:
+		return truncateBranchAtResponse(&n.BranchNode)
+	case *parse.RangeNode:
+		return truncateBranchAtResponse(&n.BranchNode)
+	case *parse.WithNode:
+		return truncateBranchAtResponse(&n.BranchNode)
+	}
+	return false
+}
+
+func truncateBranchAtResponse(n *parse.BranchNode) bool {
+	if usesResponse(n.Pipe) {
+		n.List.Nodes = nil
+		n.ElseList = nil
+		return true
+	}
+	if truncateAtResponse(n.List) {
+		n.ElseList = nil
+		return true
+	}
+	return truncateAtResponse(n.ElseList)
+}
+
+// usesResponse reports whether a pipeline refers to .Response.
+func usesResponse(pipe *parse.PipeNode) bool {
+	if pipe == nil {
+		return false
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch arg := arg.(type) {
+			case *parse.FieldNode:
+				if slices.Contains(arg.Ident, "Response") {
+					return true
+				}
+			case *parse.VariableNode:
+				if slices.Contains(arg.Ident, "Response") {
+					return true
+				}
+			case *parse.PipeNode:
+				if usesResponse(arg) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import "testing"
+
+func Test_renderTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		values   templateValues
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "prompt",
+			template: "Q: {{ .Prompt }}\nA: {{ .Response }}",
+			values:   templateValues{Prompt: "Why is the sky blue?"},
+			want:     "Q: Why is the sky blue?\nA: ",
+		},
+		{
+			name:     "text after response",
+			template: "<|user|>{{ .Prompt }}<|eot_id|><|assistant|>{{ .Response }}<|eot_id|>",
+			values:   templateValues{Prompt: "Hi"},
+			want:     "<|user|>Hi<|eot_id|><|assistant|>",
+		},
+		{
+			name:     "response in a condition",
+			template: "{{ if .System }}{{ .System }}\n{{ end }}{{ .Prompt }}\n{{ if .Prompt }}A: {{ .Response }}</s>{{ end }}{{ .Suffix }}",
+			values:   templateValues{System: "Be brief.", Prompt: "Hi", Suffix: "end"},
+			want:     "Be brief.\nHi\nA: ",
+		},
+		{
+			name:     "tested response",
+			template: "{{ .Prompt }}{{ if .Response }}{{ .Response }}</s>{{ else }}</s>{{ end }}!",
+			values:   templateValues{Prompt: "Hi"},
+			want:     "Hi",
+		},
+		{
+			name:     "system",
+			template: "{{ if .System }}<<SYS>>{{ .System }}<</SYS>>\n{{ end }}{{ .Prompt }}",
+			values:   templateValues{System: "Be brief.", Prompt: "Hi"},
+			want:     "<<SYS>>Be brief.<</SYS>>\nHi",
+		},
+		{
+			name:     "no system",
+			template: "{{ if .System }}<<SYS>>{{ .System }}<</SYS>>\n{{ end }}{{ .Prompt }}",
+			values:   templateValues{Prompt: "Hi"},
+			want:     "Hi",
+		},
+		{
+			name:     "suffix",
+			template: "<PRE> {{ .Prompt }} <SUF>{{ .Suffix }} <MID>",
+			values:   templateValues{Prompt: "func add(", Suffix: "}"},
+			want:     "<PRE> func add( <SUF>} <MID>",
+		},
+		{
+			name:     "invalid",
+			template: "{{ .Prompt ",
+			wantErr:  true,
+		},
+		{
+			name:     "unknown variable",
+			template: "{{ range .Messages }}{{ .Content }}{{ end }}",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate(tt.template, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -15,7 +15,11 @@
 package openai
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -29,73 +33,177 @@ import (
 
 func (h *handlers) ChatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		errorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		errorHandler(w, r, http.StatusBadRequest, "failed to read request body: %v", err)
 		return
 	}
 	defer r.Body.Close()
 
 	var chatReq ChatCompletionRequest
 	if err := json.Unmarshal(body, &chatReq); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to parse chat completions body: %v", err)
+		errorHandler(w, r, http.StatusBadRequest, "failed to parse chat completions body: %v", err)
+		return
+	}
+
+	responseMIMEType, responseSchema, err := toGeminiResponseFormat(chatReq.ResponseFormat)
+	if err != nil {
+		invalidParamHandler(w, r, "response_format", "invalid response_format: %v", err)
+		return
+	}
+
+	params := generationParams{
+		n:                chatReq.N,
+		stop:             chatReq.Stop,
+		maxTokens:        chatReq.MaxTokens,
+		temperature:      chatReq.Temperature,
+		topP:             chatReq.TopP,
+		frequencyPenalty: chatReq.FrequencyPenalty,
+		presencePenalty:  chatReq.PresencePenalty,
+		seed:             chatReq.Seed,
+	}
+	if param, err := params.validate(); err != nil {
+		invalidParamHandler(w, r, param, "invalid %s: %v", param, err)
 		return
 	}
 
 	model := h.geminiClient.GenerativeModel(chatReq.Model)
-	model.GenerationConfig = genai.GenerationConfig{
-		CandidateCount:   chatReq.N,
-		StopSequences:    chatReq.Stop,
-		ResponseMIMEType: "text/plain",
-		MaxOutputTokens:  chatReq.MaxTokens,
-		Temperature:      chatReq.Temperature,
-		TopP:             chatReq.TopP,
+	model.GenerationConfig = params.toGeminiConfig()
+	model.ResponseMIMEType = responseMIMEType
+	model.ResponseSchema = responseSchema
+
+	tools, err := toGeminiTools(chatReq.Tools)
+	if err != nil {
+		invalidParamHandler(w, r, "tools", "invalid tools: %v", err)
+		return
 	}
+	model.Tools = tools
+	toolConfig, err := toGeminiToolConfig(chatReq.ToolChoice)
+	if err != nil {
+		invalidParamHandler(w, r, "tool_choice", "invalid tool_choice: %v", err)
+		return
+	}
+	model.ToolConfig = toolConfig
 
-	chat := model.StartChat()
-	var lastPart genai.Part
-	for i, r := range chatReq.Messages {
-		if r.Role == "system" {
-			model.SystemInstruction = &genai.Content{
-				Role:  r.Role,
-				Parts: []genai.Part{genai.Text(r.Content)},
-			}
-			continue
-		}
-		if i == len(chatReq.Messages)-1 { // the last message
-			// TODO(jbd): This hack strips away the role of the last message.
-			// But Gemini API Go SDK doesn't give flexibility to call SendMessage
-			// with a list of contents.
-			lastPart = genai.Text(r.Content)
-			break
-		}
-		chat.History = append(chat.History, &genai.Content{
-			Role:  r.Role,
-			Parts: []genai.Part{genai.Text(r.Content)},
-		})
+	system, contents, err := h.toGeminiContents(r.Context(), chatReq.Messages)
+	if err != nil {
+		invalidParamHandler(w, r, "messages", "invalid messages: %v", err)
+		return
+	}
+	model.SystemInstruction = system
+
+	// The Go SDK sends conversations of more than one turn through
+	// a chat session, which always asks for a single candidate.
+	if len(contents) > 1 && chatReq.N != nil && *chatReq.N > 1 {
+		invalidParamHandler(w, r, "n", "invalid n: only one choice can be generated for conversations of more than one turn")
+		return
 	}
 
 	if chatReq.Stream {
-		streamingChatCompletionsHandler(w, r, chatReq.Model, chat, lastPart)
+		streamingChatCompletionsHandler(w, r, chatReq.Model, chatReq.StreamOptions, generateContentStream(r.Context(), model, contents))
 		return
 	}
 
-	geminiResp, err := chat.SendMessage(r.Context(), lastPart)
+	geminiResp, err := generateContent(r.Context(), model, contents)
 	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to generate content: %v", err)
+		geminiErrorHandler(w, r, err, "failed to generate content")
 		return
 	}
 
 	resp := toOpenAIResponse(geminiResp, "chat.completion", chatReq.Model)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode chat completions response: %v", err)
+		errorHandler(w, r, http.StatusInternalServerError, "failed to encode chat completions response: %v", err)
 		return
 	}
 }
 
+// generateContent sends a conversation to Gemini. The Go SDK only takes
+// a whole conversation through a chat session, so conversations of more
+// than one turn are sent through one, the others are sent as they are.
+// contents must end on a user turn.
+func generateContent(ctx context.Context, model *genai.GenerativeModel, contents []*genai.Content) (*genai.GenerateContentResponse, error) {
+	last := contents[len(contents)-1].Parts
+	if len(contents) == 1 {
+		return model.GenerateContent(ctx, last...)
+	}
+	chat := model.StartChat()
+	chat.History = contents[:len(contents)-1]
+	return chat.SendMessage(ctx, last...)
+}
+
+// generateContentStream is like generateContent, but streams the response.
+func generateContentStream(ctx context.Context, model *genai.GenerativeModel, contents []*genai.Content) *genai.GenerateContentResponseIterator {
+	last := contents[len(contents)-1].Parts
+	if len(contents) == 1 {
+		return model.GenerateContentStream(ctx, last...)
+	}
+	chat := model.StartChat()
+	chat.History = contents[:len(contents)-1]
+	return chat.SendMessageStream(ctx, last...)
+}
+
+// generationParams are the generation parameters
+// chat and text completions have in common.
+type generationParams struct {
+	n           *int32
+	stop        []string
+	maxTokens   *int32
+	temperature *float32
+	topP        *float32
+
+	// Gemini supports the penalties and the seed, but the Go SDK
+	// has no fields for them, so they can't be forwarded yet.
+	frequencyPenalty *float32
+	presencePenalty  *float32
+	seed             *int64
+}
+
+// validate reports the parameter that has a value Gemini would reject,
+// or that can't be forwarded to Gemini. Rather than clamping or dropping
+// such values, and silently generating something else than what was
+// asked for, they are rejected with a 400 error.
+func (p generationParams) validate() (param string, err error) {
+	if err := validatePenalty(p.frequencyPenalty); err != nil {
+		return "frequency_penalty", err
+	}
+	if err := validatePenalty(p.presencePenalty); err != nil {
+		return "presence_penalty", err
+	}
+	if p.seed != nil {
+		return "seed", fmt.Errorf("seed is not supported yet")
+	}
+	return "", nil
+}
+
+// validatePenalty checks that a penalty is in [-2.0, 2.0], the range
+// both OpenAI and Gemini accept. Only 0, which means no penalty, is
+// accepted for now, as penalties can't be forwarded to Gemini yet.
+func validatePenalty(v *float32) error {
+	if v == nil {
+		return nil
+	}
+	if *v < -2 || *v > 2 {
+		return fmt.Errorf("%v is not between -2.0 and 2.0", *v)
+	}
+	if *v != 0 {
+		return fmt.Errorf("penalties are not supported yet, only 0 is accepted")
+	}
+	return nil
+}
+
+func (p generationParams) toGeminiConfig() genai.GenerationConfig {
+	return genai.GenerationConfig{
+		CandidateCount:  p.n,
+		StopSequences:   p.stop,
+		MaxOutputTokens: p.maxTokens,
+		Temperature:     p.temperature,
+		TopP:            p.topP,
+	}
+}
+
 func toOpenAIResponse(from *genai.GenerateContentResponse, object, model string) (to ChatCompletionResponse) {
 	to.Object = object
 	to.Created = time.Now().Unix()
@@ -111,23 +219,40 @@ func toOpenAIResponse(from *genai.GenerateContentResponse, object, model string)
 	to.Choices = make([]ChatCompletionChoice, 0, len(from.Candidates))
 	for i, c := range from.Candidates {
 		var builder strings.Builder
-		for _, p := range c.Content.Parts {
-			content, ok := p.(genai.Text)
-			if !ok {
+		var toolCalls []ToolCall
+		// Blocked candidates have no content.
+		var parts []genai.Part
+		if c.Content != nil {
+			parts = c.Content.Parts
+		}
+		for _, p := range parts {
+			switch v := p.(type) {
+			case genai.Text:
+				builder.WriteString(string(v))
+			case genai.FunctionCall:
+				toolCall, err := toOpenAIToolCall(v)
+				if err != nil {
+					log.Printf("failed to process function call %q: %v", v.Name, err)
+					continue
+				}
+				toolCalls = append(toolCalls, toolCall)
+			default:
 				log.Printf("failed to process content part; type = %v", reflect.TypeOf(p))
-				continue
 			}
-			builder.WriteString(string(content))
 		}
 		choice := ChatCompletionChoice{
 			Index: i,
 			Message: ChatMessage{
-				Role:    c.Content.Role,
-				Content: builder.String(),
+				Role:      "assistant",
+				Content:   builder.String(),
+				ToolCalls: toolCalls,
 			},
 		}
 
 		finishReason := toGeminiFinishReason(c.FinishReason)
+		if len(toolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
 		if finishReason != "" {
 			choice.FinishReason = finishReason
 		}
@@ -136,6 +261,200 @@ func toOpenAIResponse(from *genai.GenerateContentResponse, object, model string)
 	return to
 }
 
+// toOpenAIToolCall converts a function call predicted by Gemini
+// into an OpenAI tool call. Gemini doesn't identify calls, so a
+// random ID is assigned for clients to refer back to.
+func toOpenAIToolCall(fc genai.FunctionCall) (ToolCall, error) {
+	args := fc.Args
+	if args == nil {
+		args = map[string]any{}
+	}
+	b, err := json.Marshal(args)
+	if err != nil {
+		return ToolCall{}, err
+	}
+	return ToolCall{
+		ID:   newID("call_"),
+		Type: "function",
+		Function: FunctionCall{
+			Name:      fc.Name,
+			Arguments: string(b),
+		},
+	}, nil
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + hex.EncodeToString(b)
+}
+
+// toGeminiContents translates the chat history into a Gemini system
+// instruction and conversation, as internal.ToConversation does.
+func (h *handlers) toGeminiContents(ctx context.Context, messages []ChatMessage) (system *genai.Content, contents []*genai.Content, err error) {
+	toolNames := make(map[string]string)
+	contents = make([]*genai.Content, 0, len(messages))
+	for i, m := range messages {
+		content, err := h.toGeminiContent(ctx, m, toolNames)
+		if err != nil {
+			return nil, nil, fmt.Errorf("messages[%d]: %v", i, err)
+		}
+		contents = append(contents, content)
+	}
+	return internal.ToConversation(contents)
+}
+
+// toGeminiContent converts an OpenAI chat message into Gemini content.
+// toolNames records the function name of every tool call seen so far,
+// as "tool" messages only refer to the call by its ID but Gemini needs
+// the function name in the response.
+func (h *handlers) toGeminiContent(ctx context.Context, m ChatMessage, toolNames map[string]string) (*genai.Content, error) {
+	var role string
+	switch m.Role {
+	case "system", "developer":
+		role = "system"
+	case "user":
+		role = "user"
+	case "assistant", "model":
+		// Older versions of the proxy responded with
+		// the Gemini role, which clients send back.
+		role = "model"
+	case "tool", "function":
+		name := m.Name
+		if name == "" {
+			name = toolNames[m.ToolCallID]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("tool_call_id %q doesn't match any previous tool call", m.ToolCallID)
+		}
+		// Gemini expects function responses in user turns.
+		return &genai.Content{
+			Role: "user",
+			Parts: []genai.Part{genai.FunctionResponse{
+				Name:     name,
+				Response: internal.ToFunctionResponse(m.text()),
+			}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown role %q", m.Role)
+	}
+
+	var parts []genai.Part
+	for _, p := range m.Parts {
+		part, err := h.toGeminiPart(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(m.Parts) == 0 && (m.Content != "" || len(m.ToolCalls) == 0) {
+		parts = append(parts, genai.Text(m.Content))
+	}
+	for _, tc := range m.ToolCalls {
+		var args map[string]any
+		if tc.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				return nil, fmt.Errorf("arguments of tool call %q are not a JSON object: %v", tc.ID, err)
+			}
+		}
+		toolNames[tc.ID] = tc.Function.Name
+		parts = append(parts, genai.FunctionCall{
+			Name: tc.Function.Name,
+			Args: args,
+		})
+	}
+	return &genai.Content{Role: role, Parts: parts}, nil
+}
+
+func (h *handlers) toGeminiPart(ctx context.Context, p ContentPart) (genai.Part, error) {
+	switch p.Type {
+	case "text":
+		return genai.Text(p.Text), nil
+	case "image_url":
+		if p.ImageURL == nil {
+			return nil, fmt.Errorf("image_url part has no image_url")
+		}
+		blob, err := h.images.load(ctx, p.ImageURL.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image: %v", err)
+		}
+		return blob, nil
+	default:
+		return nil, fmt.Errorf("unsupported content part type %q", p.Type)
+	}
+}
+
+// toGeminiResponseFormat returns the response MIME type
+// and schema Gemini needs to honor the response format.
+func toGeminiResponseFormat(f *ResponseFormat) (string, *genai.Schema, error) {
+	if f == nil {
+		return "text/plain", nil, nil
+	}
+	switch f.Type {
+	case "", "text":
+		return "text/plain", nil, nil
+	case "json_object":
+		return "application/json", nil, nil
+	case "json_schema":
+		if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+			return "", nil, fmt.Errorf("json_schema.schema is required")
+		}
+		schema, err := internal.ToGeminiSchema(f.JSONSchema.Schema)
+		if err != nil {
+			return "", nil, fmt.Errorf("schema %q: %v", f.JSONSchema.Name, err)
+		}
+		if schema.Description == "" {
+			schema.Description = f.JSONSchema.Description
+		}
+		return "application/json", schema, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported type %q", f.Type)
+	}
+}
+
+func toGeminiTools(tools []Tool) ([]*genai.Tool, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+	decls := make([]*genai.FunctionDeclaration, 0, len(tools))
+	for _, t := range tools {
+		if t.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type %q", t.Type)
+		}
+		decl, err := internal.ToFunctionDeclaration(t.Function.Name, t.Function.Description, t.Function.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		decls = append(decls, decl)
+	}
+	return []*genai.Tool{{FunctionDeclarations: decls}}, nil
+}
+
+func toGeminiToolConfig(choice *ToolChoice) (*genai.ToolConfig, error) {
+	if choice == nil {
+		return nil, nil
+	}
+	config := &genai.FunctionCallingConfig{}
+	if choice.Function != "" {
+		config.Mode = genai.FunctionCallingAny
+		config.AllowedFunctionNames = []string{choice.Function}
+		return &genai.ToolConfig{FunctionCallingConfig: config}, nil
+	}
+	switch choice.Mode {
+	case "none":
+		config.Mode = genai.FunctionCallingNone
+	case "auto":
+		config.Mode = genai.FunctionCallingAuto
+	case "required":
+		config.Mode = genai.FunctionCallingAny
+	default:
+		return nil, fmt.Errorf("unknown tool_choice %q", choice.Mode)
+	}
+	return &genai.ToolConfig{FunctionCallingConfig: config}, nil
+}
+
 func toGeminiFinishReason(code genai.FinishReason) string {
 	switch code {
 	case genai.FinishReasonStop:
@@ -15,7 +15,13 @@
 package openai
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/generative-ai-go/genai"
@@ -68,7 +74,7 @@ func Test_geminiToOpenAIResponse(t *testing.T) {
 					{
 						Index: 0,
 						Message: ChatMessage{
-							Role:    "model",
+							Role:    "assistant",
 							Content: "I'm good, how are you?",
 						},
 						FinishReason: "",
@@ -76,7 +82,7 @@ func Test_geminiToOpenAIResponse(t *testing.T) {
 					{
 						Index: 1,
 						Message: ChatMessage{
-							Role:    "model",
+							Role:    "assistant",
 							Content: "Is there anything I can help with?",
 						},
 						FinishReason: "length",
@@ -111,21 +117,411 @@ func Test_geminiToOpenAIResponse(t *testing.T) {
 					{
 						Index: 0,
 						Message: ChatMessage{
-							Role:    "model",
+							Role:    "assistant",
 							Content: "",
 						},
 					},
 				},
 			},
 		},
+		{
+			name: "blocked",
+			from: &genai.GenerateContentResponse{
+				Candidates: []*genai.Candidate{
+					{
+						Index:        0,
+						FinishReason: genai.FinishReasonSafety,
+					},
+				},
+			},
+			object: "chat.completion",
+			model:  "gemini1.5",
+			want: ChatCompletionResponse{
+				Object: "chat.completion",
+				Model:  "gemini1.5",
+				Choices: []ChatCompletionChoice{
+					{
+						Index:        0,
+						Message:      ChatMessage{Role: "assistant"},
+						FinishReason: "content_filter",
+					},
+				},
+			},
+		},
+		{
+			name: "function call",
+			from: &genai.GenerateContentResponse{
+				Candidates: []*genai.Candidate{
+					{
+						Index: 0,
+						Content: &genai.Content{
+							Parts: []genai.Part{
+								genai.FunctionCall{
+									Name: "get_weather",
+									Args: map[string]any{"city": "Paris"},
+								},
+								genai.FunctionCall{Name: "get_time"},
+							},
+							Role: "model",
+						},
+						FinishReason: genai.FinishReasonStop,
+					},
+				},
+			},
+			object: "chat.completion",
+			model:  "gemini1.5",
+			want: ChatCompletionResponse{
+				Object: "chat.completion",
+				Model:  "gemini1.5",
+				Choices: []ChatCompletionChoice{
+					{
+						Index: 0,
+						Message: ChatMessage{
+							Role: "assistant",
+							ToolCalls: []ToolCall{
+								{
+									Type: "function",
+									Function: FunctionCall{
+										Name:      "get_weather",
+										Arguments: `{"city":"Paris"}`,
+									},
+								},
+								{
+									Type: "function",
+									Function: FunctionCall{
+										Name:      "get_time",
+										Arguments: `{}`,
+									},
+								},
+							},
+						},
+						FinishReason: "tool_calls",
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := toOpenAIResponse(tt.from, tt.object, tt.model)
 			got.Created = tt.want.Created
+			// Tool call IDs are random; only check their shape.
+			for _, c := range got.Choices {
+				for i, tc := range c.Message.ToolCalls {
+					if !strings.HasPrefix(tc.ID, "call_") {
+						t.Errorf("tool call ID = %q, want call_ prefix", tc.ID)
+					}
+					c.Message.ToolCalls[i].ID = ""
+				}
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("geminiToOpenAIResponse() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_toGeminiResponseFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     *ResponseFormat
+		wantMIME   string
+		wantSchema *genai.Schema
+		wantErr    bool
+	}{
+		{
+			name:     "unset",
+			wantMIME: "text/plain",
+		},
+		{
+			name:     "json_object",
+			format:   &ResponseFormat{Type: "json_object"},
+			wantMIME: "application/json",
+		},
+		{
+			name: "json_schema",
+			format: &ResponseFormat{
+				Type: "json_schema",
+				JSONSchema: &JSONSchema{
+					Name:        "answer",
+					Description: "The answer.",
+					Schema: map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"value": map[string]any{"type": "integer"}},
+					},
+				},
+			},
+			wantMIME: "application/json",
+			wantSchema: &genai.Schema{
+				Type:        genai.TypeObject,
+				Description: "The answer.",
+				Properties:  map[string]*genai.Schema{"value": {Type: genai.TypeInteger}},
+			},
+		},
+		{
+			name: "unsupported schema",
+			format: &ResponseFormat{
+				Type: "json_schema",
+				JSONSchema: &JSONSchema{
+					Name:   "answer",
+					Schema: map[string]any{"anyOf": []any{}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "missing schema",
+			format:  &ResponseFormat{Type: "json_schema"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mime, schema, err := toGeminiResponseFormat(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toGeminiResponseFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if mime != tt.wantMIME || !reflect.DeepEqual(schema, tt.wantSchema) {
+				t.Errorf("toGeminiResponseFormat() = %q, %+v, want %q, %+v", mime, schema, tt.wantMIME, tt.wantSchema)
+			}
+		})
+	}
+}
+
+func TestChatMessageUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    ChatMessage
+		wantErr bool
+	}{
+		{
+			name: "string",
+			data: `{"role": "user", "content": "hello"}`,
+			want: ChatMessage{Role: "user", Content: "hello"},
+		},
+		{
+			name: "null",
+			data: `{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "f", "arguments": "{}"}}]}`,
+			want: ChatMessage{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "f", Arguments: "{}"}}}},
+		},
+		{
+			name: "parts",
+			data: `{"role": "user", "content": [{"type": "text", "text": "what is this?"}, {"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}]}`,
+			want: ChatMessage{Role: "user", Parts: []ContentPart{
+				{Type: "text", Text: "what is this?"},
+				{Type: "image_url", ImageURL: &ImageURL{URL: "data:image/png;base64,AAAA"}},
+			}},
+		},
+		{
+			name:    "invalid",
+			data:    `{"role": "user", "content": 42}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ChatMessage
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_imageLoaderDataURI(t *testing.T) {
+	l := newImageLoader(Config{MaxImageSize: 4})
+	blob, err := l.load(context.Background(), "data:image/png;base64,AQID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (genai.Blob{MIMEType: "image/png", Data: []byte{1, 2, 3}}); !reflect.DeepEqual(blob, want) {
+		t.Errorf("load() = %v, want %v", blob, want)
+	}
+	for _, url := range []string{
+		"data:image/png;base64,AQIDBAUG",
+		"data:image/gif;base64,AQID",
+		"data:image/png,AQID",
+		"https://example.com/cat.png",
+	} {
+		if _, err := l.load(context.Background(), url); err == nil {
+			t.Errorf("load(%q) succeeded, want error", url)
+		}
+	}
+}
+
+func Test_imageLoaderPrivateURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("image fetched from a loopback address")
+	}))
+	defer srv.Close()
+
+	l := newImageLoader(Config{AllowImageURLs: true})
+	if _, err := l.load(context.Background(), srv.URL+"/cat.png"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("load() error = %v, want not allowed", err)
+	}
+}
+
+func Test_isPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func Test_toGeminiContents(t *testing.T) {
+	h := &handlers{images: newImageLoader(Config{})}
+	tests := []struct {
+		name         string
+		messages     []ChatMessage
+		wantSystem   *genai.Content
+		wantContents []*genai.Content
+		wantErr      bool
+	}{
+		{
+			name: "conversation",
+			messages: []ChatMessage{
+				{Role: "system", Content: "Be brief."},
+				{Role: "developer", Content: "Answer in French."},
+				{Role: "user", Content: "Hi"},
+				{Role: "user", Content: "What's the weather in Paris and Rome?"},
+				{Role: "assistant", ToolCalls: []ToolCall{
+					{ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+					{ID: "call_2", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Rome"}`}},
+				}},
+				{Role: "tool", ToolCallID: "call_1", Content: `{"celsius":20}`},
+				{Role: "tool", ToolCallID: "call_2", Content: "sunny"},
+			},
+			wantSystem: &genai.Content{
+				Role:  "system",
+				Parts: []genai.Part{genai.Text("Be brief."), genai.Text("Answer in French.")},
+			},
+			wantContents: []*genai.Content{
+				{Role: "user", Parts: []genai.Part{genai.Text("Hi"), genai.Text("What's the weather in Paris and Rome?")}},
+				{Role: "model", Parts: []genai.Part{
+					genai.FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Paris"}},
+					genai.FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Rome"}},
+				}},
+				{Role: "user", Parts: []genai.Part{
+					genai.FunctionResponse{Name: "get_weather", Response: map[string]any{"celsius": float64(20)}},
+					genai.FunctionResponse{Name: "get_weather", Response: map[string]any{"content": "sunny"}},
+				}},
+			},
+		},
+		{
+			name: "previous response",
+			messages: []ChatMessage{
+				{Role: "user", Content: "Hi"},
+				{Role: "model", Content: "Hello"},
+				{Role: "user", Content: "Bye"},
+			},
+			wantContents: []*genai.Content{
+				{Role: "user", Parts: []genai.Part{genai.Text("Hi")}},
+				{Role: "model", Parts: []genai.Part{genai.Text("Hello")}},
+				{Role: "user", Parts: []genai.Part{genai.Text("Bye")}},
+			},
+		},
+		{
+			name: "ends on assistant",
+			messages: []ChatMessage{
+				{Role: "user", Content: "Hi"},
+				{Role: "assistant", Content: "Hello"},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "only system",
+			messages: []ChatMessage{{Role: "system", Content: "Be brief."}},
+			wantErr:  true,
+		},
+		{
+			name: "unknown tool call",
+			messages: []ChatMessage{
+				{Role: "tool", ToolCallID: "call_1", Content: "sunny"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system, contents, err := h.toGeminiContents(context.Background(), tt.messages)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toGeminiContents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(system, tt.wantSystem) {
+				t.Errorf("toGeminiContents() system = %+v, want %+v", system, tt.wantSystem)
+			}
+			if !reflect.DeepEqual(contents, tt.wantContents) {
+				t.Errorf("toGeminiContents() contents = %+v, want %+v", contents, tt.wantContents)
+			}
+		})
+	}
+}
+
+func Test_generationParamsValidate(t *testing.T) {
+	zero, one, three := float32(0), float32(1), float32(3)
+	seed := int64(42)
+	tests := []struct {
+		name      string
+		params    generationParams
+		wantParam string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:   "no penalties",
+			params: generationParams{frequencyPenalty: &zero, presencePenalty: &zero},
+		},
+		{
+			name:      "frequency penalty out of range",
+			params:    generationParams{frequencyPenalty: &three},
+			wantParam: "frequency_penalty",
+		},
+		{
+			name:      "presence penalty",
+			params:    generationParams{presencePenalty: &one},
+			wantParam: "presence_penalty",
+		},
+		{
+			name:      "seed",
+			params:    generationParams{seed: &seed},
+			wantParam: "seed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			param, err := tt.params.validate()
+			if (err != nil) != (tt.wantParam != "") {
+				t.Fatalf("validate() error = %v, want error for %q", err, tt.wantParam)
+			}
+			if param != tt.wantParam {
+				t.Errorf("validate() param = %q, want %q", param, tt.wantParam)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// CompletionsHandler implements the legacy text completions API.
+// Every prompt is sent to Gemini as a separate request, the choices
+// of the i-th prompt are at indexes i*n to (i+1)*n-1.
+func (h *handlers) CompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(w, r, http.StatusBadRequest, "failed to read request body: %v", err)
+		return
+	}
+	defer r.Body.Close()
+
+	var req CompletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		errorHandler(w, r, http.StatusBadRequest, "failed to parse completions body: %v", err)
+		return
+	}
+	if len(req.Prompt) == 0 {
+		invalidParamHandler(w, r, "prompt", "prompt is required")
+		return
+	}
+
+	params := generationParams{
+		n:                req.N,
+		stop:             req.Stop,
+		maxTokens:        req.MaxTokens,
+		temperature:      req.Temperature,
+		topP:             req.TopP,
+		frequencyPenalty: req.FrequencyPenalty,
+		presencePenalty:  req.PresencePenalty,
+		seed:             req.Seed,
+	}
+	if param, err := params.validate(); err != nil {
+		invalidParamHandler(w, r, param, "invalid %s: %v", param, err)
+		return
+	}
+
+	model := h.geminiClient.GenerativeModel(req.Model)
+	model.GenerationConfig = params.toGeminiConfig()
+	model.ResponseMIMEType = "text/plain"
+
+	prompts := []string(req.Prompt)
+	if req.Suffix != "" {
+		prompts = make([]string, len(req.Prompt))
+		var system string
+		for i, p := range req.Prompt {
+			system, prompts[i] = internal.FillInTheMiddle(p, req.Suffix)
+		}
+		model.SystemInstruction = &genai.Content{
+			Role:  "system",
+			Parts: []genai.Part{genai.Text(system)},
+		}
+	}
+
+	if req.Stream {
+		streamingCompletionsHandler(w, r, &req, prompts, func(prompt string) contentStream {
+			return model.GenerateContentStream(r.Context(), genai.Text(prompt))
+		})
+		return
+	}
+
+	n := candidateCount(req.N)
+	resp := &CompletionResponse{
+		ID:      newID("cmpl-"),
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: make([]CompletionChoice, 0, len(prompts)*n),
+		Usage:   &Usage{},
+	}
+	for i, prompt := range prompts {
+		gresp, err := model.GenerateContent(r.Context(), genai.Text(prompt))
+		if err != nil {
+			geminiErrorHandler(w, r, err, "failed to generate content")
+			return
+		}
+		for _, c := range gresp.Candidates {
+			choice := CompletionChoice{
+				Index: i*n + int(c.Index),
+				Text:  candidateText(c),
+			}
+			if req.Suffix != "" {
+				choice.Text = internal.StripFillInTheMiddle(choice.Text, req.Prompt[i], req.Suffix)
+			}
+			if req.Echo {
+				choice.Text = req.Prompt[i] + choice.Text
+			}
+			reason := toGeminiFinishReason(c.FinishReason)
+			if reason == "" {
+				reason = "stop"
+			}
+			choice.FinishReason = &reason
+			resp.Choices = append(resp.Choices, choice)
+		}
+		addUsage(resp.Usage, gresp.UsageMetadata)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		errorHandler(w, r, http.StatusInternalServerError, "failed to encode completions response: %v", err)
+		return
+	}
+}
+
+// streamingCompletionsHandler streams the choices of every prompt in
+// turn. With a suffix, every choice is stripped of what Gemini repeats
+// of the prompt and the suffix, which holds back the end of the text
+// until the choice is finished.
+func streamingCompletionsHandler(w http.ResponseWriter, r *http.Request, req *CompletionRequest, prompts []string, stream func(prompt string) contentStream) {
+	id := newID("cmpl-")
+	newChunk := func() CompletionResponse {
+		return CompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+		}
+	}
+	n := candidateCount(req.N)
+	usage := &Usage{}
+	sse := newEventWriter(w)
+	for i, prompt := range prompts {
+		iter := stream(prompt)
+		echoed := make(map[int]bool)
+		strippers := make(map[int]*internal.FillInTheMiddleStripper)
+		var last *genai.UsageMetadata
+		for {
+			gresp, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				if !sse.started {
+					geminiErrorHandler(w, r, err, "failed to stream response")
+					return
+				}
+				sse.writeError(internal.StatusCode(err), fmt.Sprintf("failed to stream response: %v", err))
+				return
+			}
+			if gresp.UsageMetadata != nil {
+				last = gresp.UsageMetadata
+			}
+
+			chunk := newChunk()
+			for _, c := range gresp.Candidates {
+				index := i*n + int(c.Index)
+				choice := CompletionChoice{Index: index}
+				if req.Echo && !echoed[index] {
+					choice.Text = req.Prompt[i]
+					echoed[index] = true
+				}
+				text := candidateText(c)
+				if req.Suffix != "" {
+					s, ok := strippers[index]
+					if !ok {
+						s = internal.NewFillInTheMiddleStripper(req.Prompt[i], req.Suffix)
+						strippers[index] = s
+					}
+					text = s.Write(text)
+					if c.FinishReason != genai.FinishReasonUnspecified {
+						text += s.Flush()
+						delete(strippers, index)
+					}
+				}
+				choice.Text += text
+				if reason := toGeminiFinishReason(c.FinishReason); reason != "" {
+					choice.FinishReason = &reason
+				}
+				if choice.Text == "" && choice.FinishReason == nil {
+					continue
+				}
+				chunk.Choices = append(chunk.Choices, choice)
+			}
+			if len(chunk.Choices) == 0 {
+				continue
+			}
+			if err := sse.writeJSON(chunk); err != nil {
+				log.Printf("Error writing chunk: %v", err)
+				return
+			}
+		}
+
+		// Choices that ended without a finish reason
+		// still have the end of their text held back.
+		chunk := newChunk()
+		for index := i * n; index < (i+1)*n; index++ {
+			if s, ok := strippers[index]; ok {
+				if text := s.Flush(); text != "" {
+					chunk.Choices = append(chunk.Choices, CompletionChoice{Index: index, Text: text})
+				}
+			}
+		}
+		if len(chunk.Choices) > 0 {
+			if err := sse.writeJSON(chunk); err != nil {
+				log.Printf("Error writing chunk: %v", err)
+				return
+			}
+		}
+		addUsage(usage, last)
+	}
+	if req.StreamOptions.IncludeUsage {
+		chunk := newChunk()
+		chunk.Choices = []CompletionChoice{}
+		chunk.Usage = usage
+		if err := sse.writeJSON(chunk); err != nil {
+			log.Printf("Error writing chunk: %v", err)
+			return
+		}
+	}
+	sse.write([]byte("[DONE]"))
+}
+
+func candidateCount(n *int32) int {
+	if n == nil || *n < 1 {
+		return 1
+	}
+	return int(*n)
+}
+
+// candidateText returns the text parts of a candidate.
+func candidateText(c *genai.Candidate) string {
+	if c.Content == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, p := range c.Content.Parts {
+		text, ok := p.(genai.Text)
+		if !ok {
+			log.Printf("failed to process content part; type = %v", reflect.TypeOf(p))
+			continue
+		}
+		b.WriteString(string(text))
+	}
+	return b.String()
+}
+
+func addUsage(to *Usage, from *genai.UsageMetadata) {
+	if from == nil {
+		return
+	}
+	to.PromptTokens += from.PromptTokenCount
+	to.CompletionTokens += from.CandidatesTokenCount
+	to.TotalTokens += from.TotalTokenCount
+}
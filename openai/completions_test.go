@@ -0,0 +1,240 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+const (
+	fimPrefix = "func add(a, b int) int {\n\t"
+	fimSuffix = "\n}\n"
+)
+
+// fakeReplies returns the replies of the fake Gemini to a prompt: the
+// prompt and the index of the candidate, or to a fill-in-the-middle
+// prompt, a reply that repeats the prefix and the suffix.
+func fakeReplies(prompt string, n int) []string {
+	var replies []string
+	for i := 0; i < max(n, 1); i++ {
+		reply := fmt.Sprintf("%s-%d", prompt, i)
+		if strings.HasPrefix(prompt, "<prefix>") {
+			reply = "func add(a, b int) int {\n\treturn a + b\n}\n"
+		}
+		replies = append(replies, reply)
+	}
+	return replies
+}
+
+// newFakeGemini returns a client of a fake Gemini that generates the
+// fakeReplies.
+func newFakeGemini(t *testing.T) *genai.Client {
+	type part struct {
+		Text string `json:"text"`
+	}
+	type content struct {
+		Role  string `json:"role,omitempty"`
+		Parts []part `json:"parts"`
+	}
+	type candidate struct {
+		Index        int     `json:"index"`
+		Content      content `json:"content"`
+		FinishReason string  `json:"finishReason,omitempty"`
+	}
+	type response struct {
+		Candidates    []candidate    `json:"candidates"`
+		UsageMetadata map[string]int `json:"usageMetadata,omitempty"`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Contents         []content `json:"contents"`
+			GenerationConfig struct {
+				CandidateCount int `json:"candidateCount"`
+			} `json:"generationConfig"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prompt := req.Contents[len(req.Contents)-1].Parts[0].Text
+		resp := response{
+			UsageMetadata: map[string]int{"promptTokenCount": 1, "candidatesTokenCount": 2, "totalTokenCount": 3},
+		}
+		for i, reply := range fakeReplies(prompt, req.GenerationConfig.CandidateCount) {
+			resp.Candidates = append(resp.Candidates, candidate{
+				Index:        i,
+				Content:      content{Role: "model", Parts: []part{{reply}}},
+				FinishReason: "STOP",
+			})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newFakeStream streams the fakeReplies in two halves.
+func newFakeStream(prompt string, n int) contentStream {
+	first := &genai.GenerateContentResponse{}
+	second := &genai.GenerateContentResponse{
+		UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 1, CandidatesTokenCount: 2, TotalTokenCount: 3},
+	}
+	for i, reply := range fakeReplies(prompt, n) {
+		first.Candidates = append(first.Candidates, &genai.Candidate{
+			Index:   int32(i),
+			Content: &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(reply[:len(reply)/2])}},
+		})
+		second.Candidates = append(second.Candidates, &genai.Candidate{
+			Index:        int32(i),
+			Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(reply[len(reply)/2:])}},
+			FinishReason: genai.FinishReasonStop,
+		})
+	}
+	return &fakeStream{responses: []*genai.GenerateContentResponse{first, second}}
+}
+
+func TestCompletionsHandler(t *testing.T) {
+	h := &handlers{geminiClient: newFakeGemini(t)}
+	tests := []struct {
+		name      string
+		body      string
+		want      map[int]string
+		wantUsage Usage
+	}{
+		{
+			name:      "prompts and n",
+			body:      `{"model": "gemini-1.5-flash", "prompt": ["a", "b"], "n": 2}`,
+			want:      map[int]string{0: "a-0", 1: "a-1", 2: "b-0", 3: "b-1"},
+			wantUsage: Usage{PromptTokens: 2, CompletionTokens: 4, TotalTokens: 6},
+		},
+		{
+			name:      "echo",
+			body:      `{"model": "gemini-1.5-flash", "prompt": "a", "echo": true}`,
+			want:      map[int]string{0: "aa-0"},
+			wantUsage: Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3},
+		},
+		{
+			name:      "suffix",
+			body:      fmt.Sprintf(`{"model": "gemini-1.5-flash", "prompt": %q, "suffix": %q}`, fimPrefix, fimSuffix),
+			want:      map[int]string{0: "return a + b"},
+			wantUsage: Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.CompletionsHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var resp CompletionResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[int]string)
+			for _, c := range resp.Choices {
+				got[c.Index] = c.Text
+				if c.FinishReason == nil || *c.FinishReason != "stop" {
+					t.Errorf("choice %d finish_reason = %v, want stop", c.Index, c.FinishReason)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("choices = %v, want %v", got, tt.want)
+			}
+			if resp.Usage == nil || *resp.Usage != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", resp.Usage, tt.wantUsage)
+			}
+		})
+	}
+
+	// Streaming sends the same choices, followed by the usage.
+	for _, tt := range tests {
+		t.Run(tt.name+" streaming", func(t *testing.T) {
+			var req CompletionRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			req.StreamOptions.IncludeUsage = true
+			prompts := []string(req.Prompt)
+			if req.Suffix != "" {
+				_, prompt := internal.FillInTheMiddle(req.Prompt[0], req.Suffix)
+				prompts = []string{prompt}
+			}
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/completions", nil)
+			streamingCompletionsHandler(rec, r, &req, prompts, func(prompt string) contentStream {
+				return newFakeStream(prompt, candidateCount(req.N))
+			})
+
+			events := readEvents(t, rec.Body.String())
+			if len(events) == 0 || events[len(events)-1] != "[DONE]" {
+				t.Fatalf("stream didn't end with [DONE]: %q", events)
+			}
+			var chunks []CompletionResponse
+			for _, data := range events[:len(events)-1] {
+				var chunk CompletionResponse
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					t.Fatalf("invalid chunk %q: %v", data, err)
+				}
+				chunks = append(chunks, chunk)
+			}
+			if len(chunks) == 0 {
+				t.Fatal("no chunks streamed")
+			}
+
+			got := make(map[int]string)
+			finished := make(map[int]bool)
+			for _, chunk := range chunks[:len(chunks)-1] {
+				if chunk.Usage != nil {
+					t.Errorf("usage sent before the last chunk")
+				}
+				for _, c := range chunk.Choices {
+					got[c.Index] += c.Text
+					if c.FinishReason != nil {
+						finished[c.Index] = true
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("choices = %v, want %v", got, tt.want)
+			}
+			if len(finished) != len(tt.want) {
+				t.Errorf("finished choices = %v, want all of %d", finished, len(tt.want))
+			}
+			last := chunks[len(chunks)-1]
+			if len(last.Choices) != 0 || last.Usage == nil || *last.Usage != tt.wantUsage {
+				t.Errorf("last chunk = %+v, want usage %+v", last, tt.wantUsage)
+			}
+		})
+	}
+}
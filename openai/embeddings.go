@@ -15,9 +15,16 @@
 package openai
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"sync"
 
 	"github.com/google-gemini/proxy-to-gemini/internal"
 	"github.com/google/generative-ai-go/genai"
@@ -25,49 +32,158 @@ import (
 
 func (h *handlers) EmbeddingsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		internal.ErrorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		errorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to read request body: %v", err)
+		errorHandler(w, r, http.StatusBadRequest, "failed to read request body: %v", err)
 		return
 	}
 	defer r.Body.Close()
 
 	var embeddingsReq EmbeddingsRequest
 	if err := json.Unmarshal(body, &embeddingsReq); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to unmarshal request body: %v", err)
+		errorHandler(w, r, http.StatusBadRequest, "failed to unmarshal request body: %v", err)
+		return
+	}
+	if len(embeddingsReq.Input) == 0 {
+		invalidParamHandler(w, r, "input", "input is required")
+		return
+	}
+	switch embeddingsReq.EncodingFormat {
+	case "", "float", "base64":
+	default:
+		invalidParamHandler(w, r, "encoding_format", "unsupported encoding_format %q", embeddingsReq.EncodingFormat)
+		return
+	}
+	if d := embeddingsReq.Dimensions; d != nil && *d < 1 {
+		invalidParamHandler(w, r, "dimensions", "dimensions must be positive")
+		return
+	}
+	taskType, err := internal.EmbeddingTaskType(embeddingsReq.TaskType, embeddingsReq.Title, embeddingsReq.Model, h.taskTypes)
+	if err != nil {
+		invalidParamHandler(w, r, "task_type", "%v", err)
 		return
 	}
 
 	model := h.geminiClient.EmbeddingModel(embeddingsReq.Model)
-	batch := model.NewBatch()
-	for _, content := range embeddingsReq.Input {
-		batch.AddContent(genai.Text(content))
+	model.TaskType = taskType
+	if d := embeddingsReq.Dimensions; d != nil {
+		n, err := h.dimensions.get(r.Context(), model)
+		if err != nil {
+			geminiErrorHandler(w, r, err, "failed to make embeddings request")
+			return
+		}
+		if int(*d) > n {
+			invalidParamHandler(w, r, "dimensions", "model %q only supports up to %d dimensions", embeddingsReq.Model, n)
+			return
+		}
 	}
 
-	geminiResp, err := model.BatchEmbedContents(r.Context(), batch)
+	tokens := make(chan int32, 1)
+	go func() {
+		tokens <- h.countTokens(r.Context(), embeddingsReq.Input)
+	}()
+
+	embeddings, err := internal.BatchEmbed(r.Context(), model, embeddingsReq.Title, embeddingsReq.Input)
 	if err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to make embeddings request: %v", err)
+		geminiErrorHandler(w, r, err, "failed to make embeddings request")
 		return
 	}
 
+	promptTokens := <-tokens
 	embeddingsResp := &EmbeddingsResponse{
 		Object: "list",
 		Model:  embeddingsReq.Model,
-		Data:   make([]EmbeddingData, 0, len(geminiResp.Embeddings)),
+		Data:   make([]EmbeddingData, 0, len(embeddings)),
+		Usage: Usage{
+			PromptTokens: promptTokens,
+			TotalTokens:  promptTokens,
+		},
 	}
-	for i, contentEmbedding := range geminiResp.Embeddings {
-		embeddingsResp.Data = append(embeddingsResp.Data, EmbeddingData{
+	for i, values := range embeddings {
+		if d := embeddingsReq.Dimensions; d != nil {
+			// Gemini embedding models are trained so that a prefix of an
+			// embedding is an embedding itself. Reducing the dimensions
+			// is done by truncating, the same as Gemini does.
+			values = values[:min(int(*d), len(values))]
+		}
+		data := EmbeddingData{
 			Index:     i,
 			Object:    "embedding",
-			Embedding: contentEmbedding.Values,
-		})
+			Embedding: values,
+		}
+		if embeddingsReq.EncodingFormat == "base64" {
+			data.Embedding = encodeEmbedding(values)
+		}
+		embeddingsResp.Data = append(embeddingsResp.Data, data)
 	}
 	if err := json.NewEncoder(w).Encode(embeddingsResp); err != nil {
-		internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to encode embeddings response: %v", err)
+		errorHandler(w, r, http.StatusInternalServerError, "failed to encode embeddings response: %v", err)
 		return
 	}
 }
+
+// countTokens returns the number of tokens in the inputs, or zero if
+// no model is configured to count them. Usage is informational, so
+// failures are only logged.
+func (h *handlers) countTokens(ctx context.Context, inputs []string) int32 {
+	if h.tokenCountModel == "" {
+		return 0
+	}
+	n, err := internal.CountTokens(ctx, h.geminiClient.GenerativeModel(h.tokenCountModel), inputs)
+	if err != nil {
+		log.Printf("Error counting embedding tokens with %q: %v", h.tokenCountModel, err)
+		return 0
+	}
+	return n
+}
+
+// embeddingDimensions caches the number of dimensions of the embeddings
+// of each model, so requests for more dimensions than a model has are
+// rejected before their inputs are embedded.
+type embeddingDimensions struct {
+	mu   sync.Mutex
+	dims map[string]int
+}
+
+// get returns the number of dimensions of the embeddings of model.
+// Gemini doesn't list them, so the first time, a short text is
+// embedded to find out.
+func (d *embeddingDimensions) get(ctx context.Context, model *genai.EmbeddingModel) (int, error) {
+	d.mu.Lock()
+	n, ok := d.dims[model.Name()]
+	d.mu.Unlock()
+	if ok {
+		return n, nil
+	}
+
+	resp, err := model.EmbedContent(ctx, genai.Text("dimensions"))
+	if err != nil {
+		return 0, err
+	}
+	if resp.Embedding == nil {
+		return 0, fmt.Errorf("no embedding returned")
+	}
+	n = len(resp.Embedding.Values)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dims == nil {
+		d.dims = make(map[string]int)
+	}
+	d.dims[model.Name()] = n
+	return n, nil
+}
+
+// encodeEmbedding packs the values as little-endian
+// float32s and encodes them in base64.
+func encodeEmbedding(values []float32) string {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func Test_encodeEmbedding(t *testing.T) {
+	// The OpenAI Python SDK decodes this with
+	// np.frombuffer(base64.b64decode(s), dtype="float32").
+	got := encodeEmbedding([]float32{1, -2.5, 0})
+	if want := "AACAPwAAIMAAAAAA"; got != want {
+		t.Errorf("encodeEmbedding() = %q, want %q", got, want)
+	}
+}
+
+func TestEmbeddingsRequestInput(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    Strings
+		wantErr bool
+	}{
+		{data: `{"input": "hello"}`, want: Strings{"hello"}},
+		{data: `{"input": ["hello", "world"]}`, want: Strings{"hello", "world"}},
+		{data: `{"input": [1, 2, 3]}`, wantErr: true},
+		{data: `{"input": [[1, 2], [3]]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var req EmbeddingsRequest
+		err := json.Unmarshal([]byte(tt.data), &req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(req.Input, tt.want) {
+			t.Errorf("Unmarshal(%s) input = %q, want %q", tt.data, req.Input, tt.want)
+		}
+	}
+}
+
+func TestEmbeddingsHandlerDimensions(t *testing.T) {
+	var probes, batches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, ":embedContent"):
+			probes.Add(1)
+			w.Write([]byte(`{"embedding": {"values": [0.1, 0.2, 0.3, 0.4]}}`))
+		case strings.HasSuffix(r.URL.Path, ":batchEmbedContents"):
+			batches.Add(1)
+			w.Write([]byte(`{"embeddings": [{"values": [0.1, 0.2, 0.3, 0.4]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	h := &handlers{geminiClient: client}
+
+	tests := []struct {
+		body     string
+		wantCode int
+		wantLen  int
+	}{
+		{body: `{"model": "text-embedding-004", "input": "hello", "dimensions": 8}`, wantCode: http.StatusBadRequest},
+		{body: `{"model": "text-embedding-004", "input": "hello", "dimensions": 2}`, wantCode: http.StatusOK, wantLen: 2},
+		{body: `{"model": "text-embedding-004", "input": "hello"}`, wantCode: http.StatusOK, wantLen: 4},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.EmbeddingsHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(tt.body)))
+		if rec.Code != tt.wantCode {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.body, rec.Code, tt.wantCode, rec.Body)
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Data []struct {
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Data) != 1 || len(resp.Data[0].Embedding) != tt.wantLen {
+			t.Errorf("%s: got %+v, want one embedding of %d dimensions", tt.body, resp.Data, tt.wantLen)
+		}
+	}
+
+	// The dimensions are looked up once, and too many
+	// dimensions are rejected before embedding anything.
+	if n := probes.Load(); n != 1 {
+		t.Errorf("looked up the dimensions %d times, want 1", n)
+	}
+	if n := batches.Load(); n != 2 {
+		t.Errorf("embedded %d batches, want 2", n)
+	}
+}
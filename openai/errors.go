@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
+)
+
+// errorHandler responds with an error in the format OpenAI clients expect.
+func errorHandler(w http.ResponseWriter, r *http.Request, code int, msg string, arg ...interface{}) {
+	if len(arg) > 0 {
+		msg = fmt.Sprintf(msg, arg...)
+	}
+	log.Printf("Error responding: %v", msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(toErrorResponse(code, msg, ""))
+}
+
+// invalidParamHandler responds with a 400 error about the given request parameter.
+func invalidParamHandler(w http.ResponseWriter, r *http.Request, param string, msg string, arg ...interface{}) {
+	if len(arg) > 0 {
+		msg = fmt.Sprintf(msg, arg...)
+	}
+	log.Printf("Error responding: %v", msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(toErrorResponse(http.StatusBadRequest, msg, param))
+}
+
+// geminiErrorHandler responds with an error returned by the
+// Gemini API, using the status code that matches its cause.
+func geminiErrorHandler(w http.ResponseWriter, r *http.Request, err error, msg string, arg ...interface{}) {
+	if len(arg) > 0 {
+		msg = fmt.Sprintf(msg, arg...)
+	}
+	errorHandler(w, r, internal.StatusCode(err), "%s: %v", msg, err)
+}
+
+func toErrorResponse(code int, msg, param string) ErrorResponse {
+	resp := ErrorResponse{Error: Error{Message: msg}}
+	if param != "" {
+		resp.Error.Param = &param
+	}
+	var errCode string
+	switch code {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		resp.Error.Type = "invalid_request_error"
+	case http.StatusUnauthorized:
+		resp.Error.Type = "authentication_error"
+		errCode = "invalid_api_key"
+	case http.StatusForbidden:
+		resp.Error.Type = "permission_error"
+		errCode = "permission_denied"
+	case http.StatusNotFound:
+		resp.Error.Type = "invalid_request_error"
+		errCode = "model_not_found"
+	case http.StatusTooManyRequests:
+		resp.Error.Type = "requests"
+		errCode = "rate_limit_exceeded"
+	case http.StatusServiceUnavailable:
+		resp.Error.Type = "server_error"
+		errCode = "service_unavailable"
+	default:
+		resp.Error.Type = "server_error"
+	}
+	if errCode != "" {
+		resp.Error.Code = &errCode
+	}
+	return resp
+}
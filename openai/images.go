@@ -0,0 +1,179 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const defaultMaxImageSize = 20 << 20
+
+// supportedImageTypes are the image MIME types Gemini accepts.
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+	"image/heic": true,
+	"image/heif": true,
+}
+
+// imageLoader turns the image URLs sent in chat messages into blobs.
+type imageLoader struct {
+	allowURLs bool
+	maxSize   int64
+	client    *http.Client
+}
+
+func newImageLoader(config Config) *imageLoader {
+	maxSize := config.MaxImageSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxImageSize
+	}
+	return &imageLoader{
+		allowURLs: config.AllowImageURLs,
+		maxSize:   maxSize,
+		client:    newPublicClient(),
+	}
+}
+
+// newPublicClient returns an HTTP client that only connects to public
+// addresses, so clients of the proxy can't make it fetch URLs of the
+// network it runs in, such as cloud metadata servers. Addresses are
+// checked when connecting, which covers redirects and host names that
+// resolve to private addresses. Proxies from the environment aren't
+// used, they would connect on the client's behalf.
+func newPublicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addr.Addr()) {
+				return fmt.Errorf("connecting to %s is not allowed", addr.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// isPublicAddr reports whether addr is a public unicast address.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is private
+// too but not reported as such by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// load returns the image at url, which is either a base64
+// encoded data URI or, if enabled, an http or https URL.
+func (l *imageLoader) load(ctx context.Context, url string) (genai.Blob, error) {
+	switch {
+	case strings.HasPrefix(url, "data:"):
+		return l.decodeDataURI(url)
+	case strings.HasPrefix(url, "http://"), strings.HasPrefix(url, "https://"):
+		if !l.allowURLs {
+			return genai.Blob{}, fmt.Errorf("image URLs are not enabled on this proxy, use a data URI instead")
+		}
+		return l.fetch(ctx, url)
+	default:
+		return genai.Blob{}, fmt.Errorf("unsupported image URL scheme")
+	}
+}
+
+func (l *imageLoader) decodeDataURI(uri string) (genai.Blob, error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok {
+		return genai.Blob{}, fmt.Errorf("malformed data URI")
+	}
+	mimeType, ok := strings.CutSuffix(header, ";base64")
+	if !ok {
+		return genai.Blob{}, fmt.Errorf("data URI must be base64 encoded")
+	}
+	if err := checkImageType(mimeType); err != nil {
+		return genai.Blob{}, err
+	}
+	if int64(base64.StdEncoding.DecodedLen(len(data))) > l.maxSize {
+		return genai.Blob{}, fmt.Errorf("image is larger than %d bytes", l.maxSize)
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return genai.Blob{}, fmt.Errorf("failed to decode data URI: %v", err)
+	}
+	return genai.Blob{MIMEType: mimeType, Data: b}, nil
+}
+
+func (l *imageLoader) fetch(ctx context.Context, url string) (genai.Blob, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return genai.Blob{}, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return genai.Blob{}, fmt.Errorf("failed to fetch image: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return genai.Blob{}, fmt.Errorf("failed to fetch image: %s", resp.Status)
+	}
+	if resp.ContentLength > l.maxSize {
+		return genai.Blob{}, fmt.Errorf("image is larger than %d bytes", l.maxSize)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, l.maxSize+1))
+	if err != nil {
+		return genai.Blob{}, fmt.Errorf("failed to fetch image: %v", err)
+	}
+	if int64(len(b)) > l.maxSize {
+		return genai.Blob{}, fmt.Errorf("image is larger than %d bytes", l.maxSize)
+	}
+
+	mimeType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !supportedImageTypes[mimeType] {
+		mimeType = http.DetectContentType(b)
+	}
+	if err := checkImageType(mimeType); err != nil {
+		return genai.Blob{}, err
+	}
+	return genai.Blob{MIMEType: mimeType, Data: b}, nil
+}
+
+func checkImageType(mimeType string) error {
+	if !supportedImageTypes[mimeType] {
+		return fmt.Errorf("unsupported image type %q", mimeType)
+	}
+	return nil
+}
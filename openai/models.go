@@ -0,0 +1,96 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/gorilla/mux"
+)
+
+func (h *handlers) ModelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	models, err := h.listModels(r.Context())
+	if err != nil {
+		geminiErrorHandler(w, r, err, "failed to list models")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(&ModelsResponse{
+		Object: "list",
+		Data:   models,
+	}); err != nil {
+		errorHandler(w, r, http.StatusInternalServerError, "failed to encode models response: %v", err)
+		return
+	}
+}
+
+func (h *handlers) ModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errorHandler(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	model, err := h.getModel(r.Context(), id)
+	if err != nil {
+		geminiErrorHandler(w, r, err, "failed to get model %q", id)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(model); err != nil {
+		errorHandler(w, r, http.StatusInternalServerError, "failed to encode model response: %v", err)
+		return
+	}
+}
+
+// listModels returns the Gemini models as OpenAI models.
+func (h *handlers) listModels(ctx context.Context) ([]Model, error) {
+	infos, err := h.models.List(ctx, h.geminiClient)
+	if err != nil {
+		return nil, err
+	}
+	models := make([]Model, 0, len(infos))
+	for _, info := range infos {
+		models = append(models, toOpenAIModel(info))
+	}
+	return models, nil
+}
+
+// getModel returns the model with the given ID.
+func (h *handlers) getModel(ctx context.Context, id string) (Model, error) {
+	info, err := h.models.Get(ctx, h.geminiClient, id)
+	if err != nil {
+		return Model{}, err
+	}
+	return toOpenAIModel(info), nil
+}
+
+// toOpenAIModel converts Gemini model info into an OpenAI model.
+// The "models/" prefix is stripped, so the ID can be used as is
+// in chat completions and embeddings requests.
+func toOpenAIModel(info *genai.ModelInfo) Model {
+	return Model{
+		ID:      strings.TrimPrefix(info.Name, "models/"),
+		Object:  "model",
+		OwnedBy: "google",
+	}
+}
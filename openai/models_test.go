@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/option"
+)
+
+func TestModelsHandlers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1beta/models":
+			w.Write([]byte(`{"models": [{"name": "models/gemini-1.5-flash"}, {"name": "models/text-embedding-004"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found", "status": "NOT_FOUND"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test"), option.WithEndpoint(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	r := mux.NewRouter()
+	RegisterHandlers(r, client, Config{})
+
+	t.Run("list", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var got ModelsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := ModelsResponse{
+			Object: "list",
+			Data: []Model{
+				{ID: "gemini-1.5-flash", Object: "model", OwnedBy: "google"},
+				{ID: "text-embedding-004", Object: "model", OwnedBy: "google"},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ModelsHandler() = %+v, want %+v", got, want)
+		}
+	})
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{path: "/v1/models/gemini-1.5-flash", code: http.StatusOK},
+		{path: "/v1/models/models/gemini-1.5-flash", code: http.StatusOK},
+		{path: "/v1/models/unknown", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got Model
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if want := (Model{ID: "gemini-1.5-flash", Object: "model", OwnedBy: "google"}); got != want {
+				t.Errorf("ModelHandler() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -17,6 +17,11 @@
 package openai
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google-gemini/proxy-to-gemini/internal"
 	"github.com/google/generative-ai-go/genai"
 	"github.com/gorilla/mux"
 )
@@ -25,19 +30,87 @@ import (
 // to transform OpenAI protocol to Gemini calls.
 type handlers struct {
 	geminiClient *genai.Client
+	images       *imageLoader
+	models       internal.ModelCache
+	taskTypes    map[string]genai.TaskType
+	dimensions   embeddingDimensions
+
+	tokenCountModel string
+}
+
+// Config configures the OpenAI handlers.
+// The zero value is ready to use.
+type Config struct {
+	// AllowImageURLs enables downloading http and https image URLs
+	// sent in chat messages. Otherwise, only data URIs are accepted.
+	AllowImageURLs bool
+
+	// MaxImageSize is the maximum size of an image in bytes.
+	// If zero, images up to 20MB are accepted.
+	MaxImageSize int64
+
+	// EmbeddingTaskTypes maps embedding model names to the task
+	// type used when a request doesn't set task_type.
+	EmbeddingTaskTypes map[string]genai.TaskType
+
+	// TokenCountModel is the Gemini model that counts the tokens of
+	// embedding inputs, which embedding models can't count themselves.
+	// If empty, embedding usage is reported as zero tokens.
+	TokenCountModel string
 }
 
 // RegisterHandlers registers the HTTP handlers on the mux.
-func RegisterHandlers(r *mux.Router, geminiClient *genai.Client) {
-	handlers := &handlers{geminiClient: geminiClient}
+func RegisterHandlers(r *mux.Router, geminiClient *genai.Client, config Config) {
+	handlers := &handlers{
+		geminiClient: geminiClient,
+		images:       newImageLoader(config),
+		taskTypes:    config.EmbeddingTaskTypes,
+
+		tokenCountModel: config.TokenCountModel,
+	}
 	r.HandleFunc("/v1/embeddings", handlers.EmbeddingsHandler)
 	r.HandleFunc("/v1/chat/completions", handlers.ChatCompletionsHandler)
+	r.HandleFunc("/v1/completions", handlers.CompletionsHandler)
+	r.HandleFunc("/v1/models", handlers.ModelsHandler)
+	r.HandleFunc("/v1/models/{id:.+}", handlers.ModelHandler)
+}
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+type Error struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+type ModelsResponse struct {
+	Object string  `json:"object"`
+	Data   []Model `json:"data"`
+}
+
+type Model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
 }
 
 type EmbeddingsRequest struct {
-	Model string   `json:"model"`
-	Input []string `json:"input"`
-	User  string   `json:"user,omitempty"`
+	Model          string  `json:"model"`
+	Input          Strings `json:"input"`
+	Dimensions     *int32  `json:"dimensions,omitempty"`
+	EncodingFormat string  `json:"encoding_format,omitempty"`
+	User           string  `json:"user,omitempty"`
+
+	// TaskType and Title are Gemini extensions. TaskType is a Gemini
+	// task type such as RETRIEVAL_QUERY, and Title is the title of
+	// the documents, which implies RETRIEVAL_DOCUMENT.
+	TaskType string `json:"task_type,omitempty"`
+	Title    string `json:"title,omitempty"`
 }
 
 type EmbeddingsResponse struct {
@@ -49,9 +122,11 @@ type EmbeddingsResponse struct {
 }
 
 type EmbeddingData struct {
-	Object    string    `json:"object"`
-	Embedding []float32 `json:"embedding"`
-	Index     int       `json:"index"`
+	Object string `json:"object"`
+	// Embedding is either a []float32 or, if base64 encoding
+	// was requested, a string of packed little-endian float32s.
+	Embedding interface{} `json:"embedding"`
+	Index     int         `json:"index"`
 }
 
 type Usage struct {
@@ -61,10 +136,7 @@ type Usage struct {
 }
 
 type ChatCompletionRequest struct {
-	// TODO: Support response_format
 	// TODO: Add logit bias and logprobs/top_logprobs
-	// TODO: Support tools
-	// TODO: Support Stop to be string only
 	Model    string        `json:"model"`
 	Messages []ChatMessage `json:"messages"`
 
@@ -72,12 +144,18 @@ type ChatCompletionRequest struct {
 	StreamOptions StreamOptions `json:"stream_options,omitempty"`
 
 	N                *int32   `json:"n,omitempty"`
-	Stop             []string `json:"stop,omitempty"`
+	Stop             Strings  `json:"stop,omitempty"`
 	MaxTokens        *int32   `json:"max_tokens,omitempty"`
 	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
 	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
 	Temperature      *float32 `json:"temperature,omitempty"`
 	TopP             *float32 `json:"top_p,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+
+	Tools      []Tool      `json:"tools,omitempty"`
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
 
 	User string `json:"user,omitempty"`
 }
@@ -85,6 +163,127 @@ type ChatCompletionRequest struct {
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Parts is set instead of Content if the
+	// content was sent as an array of parts.
+	Parts []ContentPart `json:"-"`
+
+	// ToolCalls are the functions the assistant asked to call.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID identifies the call a "tool" message responds to.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+// ResponseFormat is one of "text", "json_object" and "json_schema".
+// JSONSchema is only set for the latter.
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+func (m *ChatMessage) UnmarshalJSON(data []byte) error {
+	type message ChatMessage
+	var v struct {
+		message
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = ChatMessage(v.message)
+	if len(v.Content) == 0 || string(v.Content) == "null" {
+		return nil
+	}
+	if v.Content[0] == '"' {
+		return json.Unmarshal(v.Content, &m.Content)
+	}
+	if err := json.Unmarshal(v.Content, &m.Parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of parts: %w", err)
+	}
+	return nil
+}
+
+// text returns the text content of the message.
+func (m *ChatMessage) text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	var b strings.Builder
+	for _, p := range m.Parts {
+		b.WriteString(p.Text)
+	}
+	return b.String()
+}
+
+// ContentPart is either a "text" or an "image_url" part.
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+type FunctionDefinition struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// ToolChoice is either one of "none", "auto" and "required",
+// or names the function the model must call.
+type ToolChoice struct {
+	Mode     string
+	Function string
+}
+
+func (c *ToolChoice) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Mode); err == nil {
+		return nil
+	}
+	var v struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("tool_choice must be a string or an object: %w", err)
+	}
+	if v.Type != "function" || v.Function.Name == "" {
+		return fmt.Errorf("tool_choice object must name a function")
+	}
+	c.Function = v.Function.Name
+	return nil
+}
+
+type ToolCall struct {
+	// Index is only set on streaming deltas.
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
 }
 
 type ChatCompletionResponse struct {
@@ -102,6 +301,84 @@ type ChatCompletionChoice struct {
 	FinishReason string      `json:"finish_reason"`
 }
 
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id,omitempty"`
+	Object  string                      `json:"object,omitempty"`
+	Created int64                       `json:"created,omitempty"`
+	Model   string                      `json:"model,omitempty"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+	Usage   *Usage                      `json:"usage,omitempty"`
+}
+
+type ChatCompletionChunkChoice struct {
+	Index        int              `json:"index"`
+	Delta        ChatMessageDelta `json:"delta"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+// ChatMessageDelta is the part of a message
+// that is sent in a single streaming chunk.
+type ChatMessageDelta struct {
+	Role      string     `json:"role,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+type CompletionRequest struct {
+	Model  string  `json:"model"`
+	Prompt Strings `json:"prompt"`
+	Suffix string  `json:"suffix,omitempty"`
+	Echo   bool    `json:"echo,omitempty"`
+
+	Stream        bool          `json:"stream,omitempty"`
+	StreamOptions StreamOptions `json:"stream_options,omitempty"`
+
+	N                *int32   `json:"n,omitempty"`
+	Stop             Strings  `json:"stop,omitempty"`
+	MaxTokens        *int32   `json:"max_tokens,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+
+	User string `json:"user,omitempty"`
+}
+
+type CompletionResponse struct {
+	ID      string             `json:"id,omitempty"`
+	Object  string             `json:"object,omitempty"`
+	Created int64              `json:"created,omitempty"`
+	Model   string             `json:"model,omitempty"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *Usage             `json:"usage,omitempty"`
+}
+
+type CompletionChoice struct {
+	Text         string      `json:"text"`
+	Index        int         `json:"index"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// Strings is a list of strings that can also
+// be sent as a single string, like stop and prompt.
+type Strings []string
+
+func (s *Strings) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = Strings{str}
+		return nil
+	}
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return fmt.Errorf("must be a string or an array of strings; token arrays are not supported")
+	}
+	*s = strs
+	return nil
+}
+
 type StreamOptions struct {
 	IncludeUsage bool `json:"include_usage,omitempty"`
 }
@@ -17,31 +17,253 @@ package openai
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"reflect"
+	"sort"
+	"time"
 
 	"github.com/google-gemini/proxy-to-gemini/internal"
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/iterator"
 )
 
-func streamingChatCompletionsHandler(w http.ResponseWriter, r *http.Request, model string, chat *genai.ChatSession, lastPart genai.Part) {
-	iter := chat.SendMessageStream(r.Context(), lastPart)
+// contentStream is the part of a genai.GenerateContentResponseIterator
+// that the streaming handlers use.
+type contentStream interface {
+	Next() (*genai.GenerateContentResponse, error)
+}
 
+// streamingChatCompletionsHandler streams the chunks of a chat completion
+// as server-sent events, ending with the finish reasons, the usage if
+// requested and [DONE]. Errors after the first event are sent as an
+// error event instead of [DONE].
+func streamingChatCompletionsHandler(w http.ResponseWriter, r *http.Request, model string, opts StreamOptions, iter contentStream) {
+	s := newChunkStream(model, opts.IncludeUsage)
+	sse := newEventWriter(w)
 	for {
 		gresp, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to stream response: %v", err)
+			if !sse.started {
+				geminiErrorHandler(w, r, err, "failed to stream response")
+				return
+			}
+			// The status has already been sent, report
+			// the error as an event instead.
+			sse.writeError(internal.StatusCode(err), fmt.Sprintf("failed to stream response: %v", err))
 			return
 		}
-		chunk, err := json.Marshal(toOpenAIResponse(gresp, "chat.completion.chunk", model))
-		if err != nil {
-			internal.ErrorHandler(w, r, http.StatusInternalServerError, "failed to marshal chunk: %v", err)
+		if err := sse.writeChunks(s.toOpenAIChunks(gresp)); err != nil {
+			log.Printf("Error writing chunk: %v", err)
 			return
 		}
-		fmt.Fprintf(w, "data: %s\n", chunk)
 	}
-	fmt.Fprint(w, "data: [DONE]\n")
+	if err := sse.writeChunks(s.finish()); err != nil {
+		log.Printf("Error writing chunk: %v", err)
+		return
+	}
+	sse.write([]byte("[DONE]"))
+}
+
+// eventWriter writes server-sent events and flushes after each one,
+// so clients receive every chunk as soon as it is available.
+type eventWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func newEventWriter(w http.ResponseWriter) *eventWriter {
+	flusher, _ := w.(http.Flusher)
+	return &eventWriter{w: w, flusher: flusher}
+}
+
+func (e *eventWriter) write(data []byte) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", "text/event-stream")
+		e.w.Header().Set("Cache-Control", "no-cache")
+		e.w.Header().Set("Connection", "keep-alive")
+	}
+	fmt.Fprintf(e.w, "data: %s\n\n", data)
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+}
+
+func (e *eventWriter) writeError(code int, msg string) {
+	log.Printf("Error responding: %v", msg)
+	b, err := json.Marshal(toErrorResponse(code, msg, ""))
+	if err != nil {
+		log.Printf("Error marshaling error: %v", err)
+		return
+	}
+	e.write(b)
+}
+
+func (e *eventWriter) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.write(b)
+	return nil
+}
+
+func (e *eventWriter) writeChunks(chunks []ChatCompletionChunk) error {
+	for _, c := range chunks {
+		if err := e.writeJSON(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkStream converts streamed Gemini responses into OpenAI chunks.
+//
+// Every choice starts with a delta that only carries the role, followed
+// by content deltas. Finish reasons and usage are held back until the
+// end of the stream, where finish sends them once.
+type chunkStream struct {
+	id           string
+	model        string
+	includeUsage bool
+
+	toolCalls     map[int]int    // number of tool calls sent per choice
+	finishReasons map[int]string // finish reason per choice
+	usage         *Usage
+}
+
+func newChunkStream(model string, includeUsage bool) *chunkStream {
+	return &chunkStream{
+		id:            newID("chatcmpl-"),
+		model:         model,
+		includeUsage:  includeUsage,
+		toolCalls:     make(map[int]int),
+		finishReasons: make(map[int]string),
+	}
+}
+
+// toOpenAIChunks converts a streamed Gemini response into chunks.
+// Function calls are sent the way OpenAI streams them: one delta
+// that carries the ID and the function name, followed by one that
+// carries the arguments.
+func (s *chunkStream) toOpenAIChunks(from *genai.GenerateContentResponse) []ChatCompletionChunk {
+	if from.UsageMetadata != nil {
+		s.usage = &Usage{
+			PromptTokens:     from.UsageMetadata.PromptTokenCount,
+			CompletionTokens: from.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:      from.UsageMetadata.TotalTokenCount,
+		}
+	}
+
+	role := s.newChunk()
+	chunk := s.newChunk()
+	args := s.newChunk()
+	for _, c := range from.Candidates {
+		i := int(c.Index)
+		if _, ok := s.finishReasons[i]; !ok {
+			s.finishReasons[i] = ""
+			role.Choices = append(role.Choices, ChatCompletionChunkChoice{
+				Index: i,
+				Delta: ChatMessageDelta{Role: "assistant"},
+			})
+		}
+		if reason := toGeminiFinishReason(c.FinishReason); reason != "" {
+			s.finishReasons[i] = reason
+		}
+		if c.Content == nil {
+			continue
+		}
+
+		choice := ChatCompletionChunkChoice{Index: i}
+		argsChoice := ChatCompletionChunkChoice{Index: i}
+		for _, p := range c.Content.Parts {
+			switch v := p.(type) {
+			case genai.Text:
+				choice.Delta.Content += string(v)
+			case genai.FunctionCall:
+				toolCall, err := toOpenAIToolCall(v)
+				if err != nil {
+					log.Printf("failed to process function call %q: %v", v.Name, err)
+					continue
+				}
+				index := s.toolCalls[i]
+				s.toolCalls[i]++
+				toolCall.Index = &index
+				arguments := toolCall.Function.Arguments
+				toolCall.Function.Arguments = ""
+				choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, toolCall)
+				argsChoice.Delta.ToolCalls = append(argsChoice.Delta.ToolCalls, ToolCall{
+					Index:    &index,
+					Function: FunctionCall{Arguments: arguments},
+				})
+			default:
+				log.Printf("failed to process content part; type = %v", reflect.TypeOf(p))
+			}
+		}
+		if choice.Delta.Content != "" || len(choice.Delta.ToolCalls) > 0 {
+			chunk.Choices = append(chunk.Choices, choice)
+		}
+		if len(argsChoice.Delta.ToolCalls) > 0 {
+			args.Choices = append(args.Choices, argsChoice)
+		}
+	}
+
+	var chunks []ChatCompletionChunk
+	for _, c := range []ChatCompletionChunk{role, chunk, args} {
+		if len(c.Choices) > 0 {
+			chunks = append(chunks, c)
+		}
+	}
+	return chunks
+}
+
+// finish returns the chunks that end the stream: one with the finish
+// reason of every choice and, if requested, one with the usage.
+func (s *chunkStream) finish() []ChatCompletionChunk {
+	indexes := make([]int, 0, len(s.finishReasons))
+	for i := range s.finishReasons {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	last := s.newChunk()
+	last.Choices = make([]ChatCompletionChunkChoice, 0, len(indexes))
+	for _, i := range indexes {
+		reason := s.finishReasons[i]
+		switch {
+		case s.toolCalls[i] > 0:
+			reason = "tool_calls"
+		case reason == "":
+			reason = "stop"
+		}
+		last.Choices = append(last.Choices, ChatCompletionChunkChoice{
+			Index:        i,
+			FinishReason: &reason,
+		})
+	}
+	chunks := []ChatCompletionChunk{last}
+	if s.includeUsage {
+		usage := s.newChunk()
+		usage.Choices = []ChatCompletionChunkChoice{}
+		usage.Usage = s.usage
+		if usage.Usage == nil {
+			usage.Usage = &Usage{}
+		}
+		chunks = append(chunks, usage)
+	}
+	return chunks
+}
+
+func (s *chunkStream) newChunk() ChatCompletionChunk {
+	return ChatCompletionChunk{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   s.model,
+	}
 }
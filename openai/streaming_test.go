@@ -0,0 +1,287 @@
+// Copyright 2024 Google LLC
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     https://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// fakeStream streams responses, then ends with err or iterator.Done.
+type fakeStream struct {
+	responses []*genai.GenerateContentResponse
+	err       error
+}
+
+func (s *fakeStream) Next() (*genai.GenerateContentResponse, error) {
+	if len(s.responses) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, iterator.Done
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, nil
+}
+
+// readEvents returns the data of the server-sent events in body.
+func readEvents(t *testing.T, body string) []string {
+	t.Helper()
+	var events []string
+	for _, event := range strings.Split(body, "\n\n") {
+		if event == "" {
+			continue
+		}
+		data, ok := strings.CutPrefix(event, "data: ")
+		if !ok {
+			t.Fatalf("malformed event %q", event)
+		}
+		events = append(events, data)
+	}
+	return events
+}
+
+func Test_streamingChatCompletionsHandler(t *testing.T) {
+	responses := []*genai.GenerateContentResponse{
+		{
+			Candidates: []*genai.Candidate{{
+				Content: &genai.Content{Role: "model", Parts: []genai.Part{genai.Text("Hello")}},
+			}},
+		},
+		{
+			Candidates: []*genai.Candidate{{
+				Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(" world")}},
+				FinishReason: genai.FinishReasonStop,
+			}},
+			UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 5, CandidatesTokenCount: 2, TotalTokenCount: 7},
+		},
+	}
+	tests := []struct {
+		name     string
+		stream   *fakeStream
+		wantCode int
+		want     []string // see summarizeEvent
+	}{
+		{
+			name:     "stream",
+			stream:   &fakeStream{responses: responses},
+			wantCode: http.StatusOK,
+			want: []string{
+				`{"role":"assistant"}`,
+				`{"content":"Hello"}`,
+				`{"content":" world"}`,
+				`finish:stop`,
+				`usage:{"prompt_tokens":5,"total_tokens":7,"completion_tokens":2}`,
+				`[DONE]`,
+			},
+		},
+		{
+			name:     "error after start",
+			stream:   &fakeStream{responses: responses[:1], err: context.DeadlineExceeded},
+			wantCode: http.StatusOK,
+			want: []string{
+				`{"role":"assistant"}`,
+				`{"content":"Hello"}`,
+				`error:failed to stream response: context deadline exceeded`,
+			},
+		},
+		{
+			name:     "error before start",
+			stream:   &fakeStream{err: context.DeadlineExceeded},
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			streamingChatCompletionsHandler(rec, r, "gemini-1.5-flash", StreamOptions{IncludeUsage: true}, tt.stream)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				var resp ErrorResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Error.Message == "" {
+					t.Errorf("body = %q, want an error response", rec.Body)
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("Content-Type = %q, want text/event-stream", got)
+			}
+
+			var got []string
+			for _, data := range readEvents(t, rec.Body.String()) {
+				got = append(got, summarizeEvent(t, data))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// summarizeEvent returns the delta of a chunk with a single choice,
+// its finish reason, its usage, the message of an error event or
+// the data of any other event.
+func summarizeEvent(t *testing.T, data string) string {
+	t.Helper()
+	var event struct {
+		ChatCompletionChunk
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return data
+	}
+	switch {
+	case event.Error != nil:
+		return "error:" + event.Error.Message
+	case event.Usage != nil:
+		b, _ := json.Marshal(event.Usage)
+		return "usage:" + string(b)
+	case len(event.Choices) != 1:
+		t.Fatalf("chunk %s has %d choices, want 1", data, len(event.Choices))
+	case event.Choices[0].FinishReason != nil:
+		return "finish:" + *event.Choices[0].FinishReason
+	}
+	b, _ := json.Marshal(event.Choices[0].Delta)
+	return string(b)
+}
+
+func Test_chunkStream(t *testing.T) {
+	s := newChunkStream("gemini1.5", true)
+	responses := []*genai.GenerateContentResponse{
+		{
+			Candidates: []*genai.Candidate{{
+				Content: &genai.Content{Role: "model", Parts: []genai.Part{genai.Text("Hello")}},
+			}},
+			UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 5, CandidatesTokenCount: 1, TotalTokenCount: 6},
+		},
+		{
+			Candidates: []*genai.Candidate{{
+				Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(" world")}},
+				FinishReason: genai.FinishReasonStop,
+			}},
+			UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 5, CandidatesTokenCount: 2, TotalTokenCount: 7},
+		},
+	}
+	var chunks []ChatCompletionChunk
+	for _, resp := range responses {
+		chunks = append(chunks, s.toOpenAIChunks(resp)...)
+	}
+	chunks = append(chunks, s.finish()...)
+
+	stop := "stop"
+	want := []ChatCompletionChunk{
+		{Choices: []ChatCompletionChunkChoice{{Delta: ChatMessageDelta{Role: "assistant"}}}},
+		{Choices: []ChatCompletionChunkChoice{{Delta: ChatMessageDelta{Content: "Hello"}}}},
+		{Choices: []ChatCompletionChunkChoice{{Delta: ChatMessageDelta{Content: " world"}}}},
+		{Choices: []ChatCompletionChunkChoice{{FinishReason: &stop}}},
+		{Choices: []ChatCompletionChunkChoice{}, Usage: &Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}},
+	}
+	id := s.id
+	for i := range chunks {
+		if chunks[i].ID != id || chunks[i].Object != "chat.completion.chunk" {
+			t.Errorf("chunk %d has ID %q and object %q", i, chunks[i].ID, chunks[i].Object)
+		}
+		chunks[i].ID, chunks[i].Object, chunks[i].Created, chunks[i].Model = "", "", 0, ""
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("chunks = %+v, want %+v", chunks, want)
+	}
+}
+
+func Test_chunkStreamToolCalls(t *testing.T) {
+	s := newChunkStream("gemini1.5", false)
+	responses := []*genai.GenerateContentResponse{
+		{
+			Candidates: []*genai.Candidate{{
+				Content: &genai.Content{
+					Role: "model",
+					Parts: []genai.Part{
+						genai.FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Paris"}},
+						genai.FunctionCall{Name: "get_weather", Args: map[string]any{"city": "Rome"}},
+					},
+				},
+			}},
+		},
+		{
+			Candidates: []*genai.Candidate{{
+				Content: &genai.Content{
+					Role:  "model",
+					Parts: []genai.Part{genai.FunctionCall{Name: "get_time"}},
+				},
+				FinishReason: genai.FinishReasonStop,
+			}},
+		},
+	}
+
+	type delta struct {
+		index     int
+		name      string
+		arguments string
+	}
+	var got []delta
+	var finishReason string
+	ids := make(map[int]string)
+	var chunks []ChatCompletionChunk
+	for _, resp := range responses {
+		chunks = append(chunks, s.toOpenAIChunks(resp)...)
+	}
+	chunks = append(chunks, s.finish()...)
+	for _, chunk := range chunks {
+		for _, c := range chunk.Choices {
+			for _, tc := range c.Delta.ToolCalls {
+				if tc.Index == nil {
+					t.Fatalf("tool call delta without index: %+v", tc)
+				}
+				if tc.ID != "" {
+					ids[*tc.Index] = tc.ID
+				}
+				got = append(got, delta{*tc.Index, tc.Function.Name, tc.Function.Arguments})
+			}
+			if c.FinishReason != nil {
+				finishReason = *c.FinishReason
+			}
+		}
+	}
+
+	want := []delta{
+		{0, "get_weather", ""},
+		{1, "get_weather", ""},
+		{0, "", `{"city":"Paris"}`},
+		{1, "", `{"city":"Rome"}`},
+		{2, "get_time", ""},
+		{2, "", `{}`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tool call deltas = %v, want %v", got, want)
+	}
+	if len(ids) != 3 || ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Errorf("tool call IDs = %v, want 3 distinct IDs", ids)
+	}
+	if finishReason != "tool_calls" {
+		t.Errorf("finish reason = %q, want tool_calls", finishReason)
+	}
+}